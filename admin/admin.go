@@ -0,0 +1,199 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"pucrs/sd/dimex"
+	"pucrs/sd/workload"
+	"strconv"
+)
+
+// Server is an HTTP/JSON admin server to inspect and control a running process. It serves:
+//   - GET /state: the current state of the process (and whether its workload is paused);
+//   - GET /peers: the other processes, with the status of the connection to each one;
+//   - GET /snapshot: the last snapshot completed by the process;
+//   - POST /snapshot: initiates a snapshot right away;
+//   - POST /workload/pause and POST /workload/resume: pause and resume the workload;
+//   - POST /fail: toggles the failure simulation, or sets it with the "enabled" query parameter;
+//   - POST /leave: stops the workload and makes the process leave the group;
+//   - POST /join: starts a new process, listening on the "address" query parameter, that joins the
+//     group through this process (only if enabled with WithJoinOpt).
+type Server struct {
+	dmx  *dimex.Dimex
+	gate *workload.Gate
+	join func(address string) (int, error)
+	mux  *http.ServeMux
+}
+
+// Opt is an option of a Server.
+type Opt func(*Server)
+
+// WithJoinOpt enables the POST /join endpoint, which starts a new process that joins the group
+// with the given function. The function returns the PID of the new process.
+func WithJoinOpt(join func(address string) (int, error)) Opt {
+	return func(s *Server) {
+		s.join = join
+	}
+}
+
+// NewServer creates a new instance of a Server for the given DIMEX module, which pauses and
+// resumes the workload of the process with the given gate.
+func NewServer(dmx *dimex.Dimex, gate *workload.Gate, opts ...Opt) *Server {
+	s := &Server{dmx: dmx, gate: gate, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.HandleFunc("/state", s.handleState)
+	s.mux.HandleFunc("/peers", s.handlePeers)
+	s.mux.HandleFunc("/snapshot", s.handleSnapshot)
+	s.mux.HandleFunc("/workload/pause", s.handlePause)
+	s.mux.HandleFunc("/workload/resume", s.handleResume)
+	s.mux.HandleFunc("/fail", s.handleFail)
+	s.mux.HandleFunc("/leave", s.handleLeave)
+	s.mux.HandleFunc("/join", s.handleJoin)
+
+	return s
+}
+
+// ServeHTTP dispatches the request to the handler of its endpoint.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Serve serves the admin API on an HTTP server listening on the given address, in the
+// background. It returns an error if the address cannot be listened on.
+func (s *Server) Serve(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("server.Serve: failed listening on '%s': %w", address, err)
+	}
+	go http.Serve(listener, s)
+	return nil
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		dimex.Status
+		Paused bool `json:"paused"`
+	}{s.dmx.Inspect(), s.gate.Paused()})
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.dmx.Peers())
+}
+
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		snapshot := s.dmx.LastSnapshot()
+		if snapshot == nil {
+			writeError(w, http.StatusNotFound, "no snapshot completed yet")
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshot)
+	case http.MethodPost:
+		writeJSON(w, http.StatusAccepted, map[string]int{"snapId": s.dmx.TriggerSnapshot()})
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	s.gate.Pause()
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	s.gate.Resume()
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": false})
+}
+
+func (s *Server) handleFail(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	fail := !s.dmx.Inspect().Fail
+	if enabled := r.URL.Query().Get("enabled"); enabled != "" {
+		var err error
+		if fail, err = strconv.ParseBool(enabled); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid value '%s' for 'enabled'", enabled))
+			return
+		}
+	}
+	s.dmx.SetFail(fail)
+	writeJSON(w, http.StatusOK, map[string]bool{"fail": fail})
+}
+
+func (s *Server) handleLeave(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	s.gate.Close()
+	if err := s.dmx.Leave(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"left": true})
+}
+
+func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if s.join == nil {
+		writeError(w, http.StatusNotFound, "joining the group is not enabled")
+		return
+	}
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		writeError(w, http.StatusBadRequest, "missing 'address'")
+		return
+	}
+	pid, err := s.join(address)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"pid": pid})
+}
+
+// allowMethod tells whether the request uses one of the allowed methods, replying with an error
+// if it does not.
+func allowMethod(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
+	for _, method := range allowed {
+		if r.Method == method {
+			return true
+		}
+	}
+	for _, method := range allowed {
+		w.Header().Add("Allow", method)
+	}
+	writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"pucrs/sd/bench"
+	"pucrs/sd/dimex"
+	"pucrs/sd/logging"
+	"pucrs/sd/workload"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// benchDefaultDuration is the duration of a benchmark when neither a budget nor a duration is set
+const benchDefaultDuration = 10 * time.Second
+
+// runBench implements the "bench" subcommand, which runs the configured workload on a fresh
+// cluster and reports the performance of the mutual exclusion algorithm (synchronization delay,
+// wait latency, throughput, messages per CS entry and fairness). It accepts the same flags as
+// the simulation (e.g., workload flags, -s and -f), plus -json.
+func runBench(args []string) {
+	jsonFile := flag.String("json", "", "File to write the benchmark results to, as JSON")
+	flag.CommandLine.Parse(args)
+
+	if len(flag.Args()) < 2 {
+		logrus.Errorf("Usage: %s bench [-json <file>] [-s <seconds>] [-f] [-fd <mode>] [workload flags] <address:port> <address:port> [<address:port>...]", os.Args[0])
+		os.Exit(1)
+	}
+
+	logCfg, err := loggingConfig()
+	if err != nil {
+		logrus.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	if *budget <= 0 && *duration <= 0 {
+		*duration = benchDefaultDuration
+	}
+	workloadCfg, err := workloadConfig()
+	if err != nil {
+		logrus.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	dimexOpts := []dimex.Opt{
+		dimex.WithSnapshotIntervalOpt(*snapshotSec),
+		dimex.WithLoggerOpt(logCfg.Logger(logging.DIMEX)),
+		dimex.WithSnapshotLoggerOpt(logCfg.Logger(logging.SNAPSHOTS)),
+		dimex.WithLinkLoggerOpt(logCfg.Logger(logging.PP2PLINK)),
+	}
+	if *failureMode {
+		dimexOpts = append(dimexOpts, dimex.WithFailOpt())
+	}
+	fdOpts, err := failureDetectorOpts()
+	if err != nil {
+		logrus.Errorf("%v", err)
+		os.Exit(1)
+	}
+	dimexOpts = append(dimexOpts, fdOpts...)
+
+	addresses := flag.Args()
+	logrus.Infof("Benchmarking DiMEx with %d processes (budget %d, duration %v)...", len(addresses), *budget, *duration)
+
+	nodes := make([]*dimex.Dimex, len(addresses))
+	stats := make([]workload.Stats, len(addresses))
+	var wg sync.WaitGroup
+	for i := range addresses {
+		nodes[i] = dimex.NewDimex(addresses, i, dimexOpts...)
+		wg.Add(1)
+		go func(pid int) {
+			defer wg.Done()
+			var err error
+			if stats[pid], err = workload.Run(nodes[pid], pid, workloadCfg, nil); err != nil {
+				logrus.Errorf("Error running workload: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	messages := make(map[string]int64)
+	for _, node := range nodes {
+		for kind, count := range node.MessagesSent() {
+			messages[kind] += count
+		}
+	}
+
+	result := bench.Compute(stats, messages)
+	if err := result.WriteTable(os.Stdout); err != nil {
+		logrus.Errorf("Failed to write benchmark results: %v", err)
+		os.Exit(1)
+	}
+
+	if *jsonFile == "" {
+		return
+	}
+	resultJson, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		logrus.Errorf("Failed to marshal benchmark results to JSON: %v", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*jsonFile, append(resultJson, '\n'), 0644); err != nil {
+		logrus.Errorf("Failed to write benchmark results: %v", err)
+		os.Exit(1)
+	}
+	logrus.Infof("Benchmark results written to '%s'", *jsonFile)
+}
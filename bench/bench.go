@@ -0,0 +1,164 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"pucrs/sd/common"
+	"pucrs/sd/workload"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Latencies summarizes a sample of latencies. All durations are in milliseconds.
+type Latencies struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// Result is the result of a benchmark of the mutual exclusion algorithm.
+type Result struct {
+	Processes        int              `json:"processes"`
+	Entries          int              `json:"entries"`
+	EntriesByProcess []int            `json:"entries_by_process"`
+	Elapsed          float64          `json:"elapsed_s"`
+	Throughput       float64          `json:"entries_per_s"`         // CS entries per second
+	WaitLatency      Latencies        `json:"wait_latency"`          // between a request and the entry in the CS
+	SyncDelay        Latencies        `json:"synchronization_delay"` // between an exit from the CS and the next entry, while requested
+	Messages         map[string]int64 `json:"messages"`              // messages sent by all processes, by kind
+	MessagesPerEntry float64          `json:"messages_per_entry"`    // protocol messages (not snapshots) per CS entry
+	Fairness         float64          `json:"fairness"`              // Jain's fairness index of the CS entries by process
+}
+
+// Compute computes the result of a benchmark from the statistics of the workload of each process
+// and the messages sent by all processes, by kind.
+func Compute(stats []workload.Stats, messages map[string]int64) Result {
+	result := Result{
+		Processes:        len(stats),
+		EntriesByProcess: make([]int, len(stats)),
+		Messages:         messages,
+	}
+
+	waits := make([]time.Duration, 0)
+	accesses := make([]workload.Access, 0)
+	var elapsed time.Duration
+	for i, s := range stats {
+		result.Entries += s.Entries
+		result.EntriesByProcess[i] = s.Entries
+		for _, access := range s.Accesses {
+			waits = append(waits, access.Entered.Sub(access.Requested))
+		}
+		accesses = append(accesses, s.Accesses...)
+		if s.Elapsed > elapsed {
+			elapsed = s.Elapsed
+		}
+	}
+
+	result.Elapsed = elapsed.Seconds()
+	if elapsed > 0 {
+		result.Throughput = float64(result.Entries) / elapsed.Seconds()
+	}
+	result.WaitLatency = summarize(waits)
+	result.SyncDelay = summarize(syncDelays(accesses))
+
+	protocolMessages := messages[common.REQ_ENTRY] + messages[common.RESP_OK]
+	if result.Entries > 0 {
+		result.MessagesPerEntry = float64(protocolMessages) / float64(result.Entries)
+	}
+	result.Fairness = jainIndex(result.EntriesByProcess)
+
+	return result
+}
+
+// WriteTable writes the result as a human-readable table.
+func (r Result) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Processes\t%d\n", r.Processes)
+	fmt.Fprintf(tw, "CS entries\t%d %v\n", r.Entries, r.EntriesByProcess)
+	fmt.Fprintf(tw, "Elapsed\t%.3f s\n", r.Elapsed)
+	fmt.Fprintf(tw, "Throughput\t%.2f entries/s\n", r.Throughput)
+	fmt.Fprintf(tw, "Messages per CS entry\t%.2f\n", r.MessagesPerEntry)
+	fmt.Fprintf(tw, "Fairness (Jain's index)\t%.4f\n", r.Fairness)
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "(ms)\tcount\tmean\tp50\tp90\tp99\tmax\n")
+	for _, row := range []struct {
+		name string
+		l    Latencies
+	}{{"Wait latency", r.WaitLatency}, {"Synchronization delay", r.SyncDelay}} {
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\n", row.name, row.l.Count, row.l.Mean, row.l.P50, row.l.P90, row.l.P99, row.l.Max)
+	}
+
+	return tw.Flush()
+}
+
+// syncDelays computes the synchronization delays of the accesses of all processes: the time
+// between an exit from the critical section and the next entry, when the next process to enter
+// had already requested the critical section at the time of the exit.
+func syncDelays(accesses []workload.Access) []time.Duration {
+	sort.Slice(accesses, func(i, j int) bool { return accesses[i].Entered.Before(accesses[j].Entered) })
+
+	delays := make([]time.Duration, 0)
+	for i := 1; i < len(accesses); i++ {
+		prev, next := accesses[i-1], accesses[i]
+		if next.Requested.Before(prev.Exited) {
+			delays = append(delays, next.Entered.Sub(prev.Exited))
+		}
+	}
+	return delays
+}
+
+// jainIndex computes Jain's fairness index of the given allocations, which is 1 when all
+// allocations are equal and 1/n when a single one gets everything.
+func jainIndex(allocations []int) float64 {
+	sum, sumSquares := 0.0, 0.0
+	for _, x := range allocations {
+		sum += float64(x)
+		sumSquares += float64(x) * float64(x)
+	}
+	if sumSquares == 0 {
+		return 0
+	}
+	return sum * sum / (float64(len(allocations)) * sumSquares)
+}
+
+func summarize(sample []time.Duration) Latencies {
+	if len(sample) == 0 {
+		return Latencies{}
+	}
+
+	sorted := make([]time.Duration, len(sample))
+	copy(sorted, sample)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+
+	return Latencies{
+		Count: len(sorted),
+		Mean:  ms(total / time.Duration(len(sorted))),
+		P50:   ms(percentile(sorted, 50)),
+		P90:   ms(percentile(sorted, 90)),
+		P99:   ms(percentile(sorted, 99)),
+		Max:   ms(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile returns the p-th percentile of the sorted sample (nearest-rank method).
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
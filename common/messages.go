@@ -0,0 +1,32 @@
+package common
+
+import "strings"
+
+// Kinds of messages exchanged between processes in a distributed mutual exclusion (DiMEX)
+// context. Every message is a string of ';'-separated fields, starting with its kind and
+// the PID of its sender.
+const (
+	REQ_ENTRY   string = "reqEntry"
+	RESP_OK     string = "respOk"
+	SNAP        string = "snap"
+	HEARTBEAT   string = "heartbeat"  // sent periodically by the failure detector
+	RECOVER     string = "recover"    // sent by a process recovering from a crash
+	RECOVER_ACK string = "recoverAck" // answer to a recover message
+	JOIN        string = "join"       // sent by a process to join the group (with PID -1, since it has none yet)
+	LEAVE       string = "leave"      // sent by a process to leave the group
+	VIEW        string = "view"       // new view of the group, sent by the coordinator of the membership
+)
+
+// Modes of a request to the critical section, the last field of a reqEntry message. Shared
+// requests (readers) do not conflict with each other, while exclusive requests (writers) conflict
+// with all requests.
+const (
+	SHARED    string = "shared"
+	EXCLUSIVE string = "exclusive"
+)
+
+// MessageKind returns the kind of the given message (i.e., its first field).
+func MessageKind(message string) string {
+	kind, _, _ := strings.Cut(message, ";")
+	return kind
+}
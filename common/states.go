@@ -1,5 +1,7 @@
 package common
 
+import "fmt"
+
 // State represents all possible states for a process in a ditributed mutual
 // exclusion (DiMEX) context.
 type State int
@@ -9,3 +11,16 @@ const (
 	WantMX
 	InMX
 )
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case NoMX:
+		return "NoMX"
+	case WantMX:
+		return "WantMX"
+	case InMX:
+		return "InMX"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
@@ -20,12 +20,19 @@
 package dimex
 
 import (
+	"crypto/tls"
 	"fmt"
 	"pucrs/sd/common"
+	"pucrs/sd/failuredetector"
+	"pucrs/sd/fencing"
+	"pucrs/sd/metrics"
 	"pucrs/sd/pp2plink"
 	"pucrs/sd/snapshots"
+	"pucrs/sd/trace"
+	"pucrs/sd/wal"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -36,40 +43,137 @@ import (
 // ------------------------------------------------------------------------------------
 
 const (
-	REQ_ENTRY string = "reqEntry"
-	RESP_OK   string = "respOk"
-	SNAP      string = "snap"
+	REQ_ENTRY   string = common.REQ_ENTRY
+	RESP_OK     string = common.RESP_OK
+	SNAP        string = common.SNAP
+	HEARTBEAT   string = common.HEARTBEAT
+	RECOVER     string = common.RECOVER
+	RECOVER_ACK string = common.RECOVER_ACK
+	JOIN        string = common.JOIN
+	LEAVE       string = common.LEAVE
+	VIEW        string = common.VIEW
 )
 
 type dmxReq int // enumeracao dos estados possiveis de um processo
 const (
-	ENTER dmxReq = iota
-	EXIT
+	ENTER        dmxReq = iota // pede a SC em modo exclusivo (escritor)
+	EXIT                       // libera a SC
+	ENTER_SHARED               // pede a SC em modo compartilhado (leitor), junto com outros leitores
 )
 
 type dmxResp struct { // mensagem do módulo DIMEX infrmando que pode acessar - pode ser somente um sinal (vazio)
 	// mensagem para aplicacao indicando que pode prosseguir
+	Expired bool          // o lease da SC expirou: a SC ja foi liberada (ver WithLeaseOpt)
+	Token   fencing.Token // token de fencing da entrada na SC, crescente entre entradas sucessivas
+	Err     error         // o pedido foi rejeitado (ver ErrUpgrade)
 }
 
 type Opt func(*Dimex)
 
+// Status is the current state of a process in the distributed mutual exclusion.
+type Status struct {
+	PID        int    `json:"pid"`
+	State      string `json:"state"`
+	LocalClock int    `json:"lcl"`
+	ReqTs      int    `json:"reqTs"`
+	Waiting    []bool `json:"waiting"`
+	NbrResps   int    `json:"nbrResps"`
+	Fail       bool   `json:"fail"`                // whether failure simulation is enabled
+	Suspected  []bool `json:"suspected,omitempty"` // processes suspected by the failure detector
+	Recovering bool   `json:"recovering"`          // whether the process is recovering from a crash
+	Shared     bool   `json:"shared"`              // whether the current request to the CS is shared (reader)
+	Priority   int    `json:"priority"`            // priority class of the current request to the CS
+	Holds      int    `json:"holds"`               // nested entries in the CS not exited yet
+	View       int    `json:"view"`                // number of the current view of the group (0 for the initial one)
+	Members    []bool `json:"members"`             // processes in the group in the current view
+}
+
+// Peer is another process of the system, as seen by this process.
+type Peer struct {
+	PID       int    `json:"pid"`
+	Address   string `json:"address"`
+	Connected bool   `json:"connected"` // whether there is an open connection to it
+	Suspected bool   `json:"suspected"` // whether the failure detector suspects it has crashed
+	Member    bool   `json:"member"`    // whether it is in the group in the current view
+}
+
 type Dimex struct {
 	Req                 chan dmxReq  // canal para receber pedidos da aplicacao (REQ e EXIT)
 	Ind                 chan dmxResp // canal para informar aplicacao que pode acessar
-	addresses           []string     // endereco de todos, na mesma ordem
+	addresses           []string     // endereco de todos, na mesma ordem (cresce quando processos entram no grupo)
+	addrMu              sync.RWMutex // protege a troca de addresses, lido fora do laco de eventos pelo detector de falhas
 	id                  int          // identificador do processo - é o indice no array de enderecos acima
 	st                  common.State // estado deste processo na exclusao mutua distribuida
 	waiting             []bool       // processos aguardando tem flag true
 	lcl                 int          // relogio logico local
 	reqTs               int          // timestamp local da ultima requisicao deste processo
+	shared              bool         // a ultima requisicao deste processo e compartilhada (leitor)
+	reqPriority         int          // classe de prioridade da ultima requisicao deste processo
+	priority            int          // classe de prioridade das proximas requisicoes (ver SetPriority)
+	aging               int          // ticks de Lamport para um pedido ganhar uma classe de prioridade
 	nbrResps            int
-	dbg                 bool
-	fail                bool // flag to simulate failures and trigger snapshot invariant violations
+	holds               int  // entradas (aninhadas) da aplicacao na SC corrente ainda sem EXIT
+	fail                bool // flag to simulate failures (permissions never delayed) and trigger snapshot invariant violations
 	snapshotIntervalSec float64
 
 	Pp2plink *pp2plink.PP2PLink // acesso aa comunicacao enviar por PP2PLinq.Req  e receber por PP2PLinq.Ind
 
-	lastSnapshot *snapshots.Snapshot
+	lastSnapshot      *snapshots.Snapshot
+	lastCompletedSnap *snapshots.Snapshot  // ultimo snapshot completo (imutavel)
+	collector         *snapshots.Collector // verifies the completed snapshots online (optional)
+
+	tracer *trace.Tracer // records protocol events with vector timestamps (optional)
+
+	sentMu sync.Mutex
+	sent   map[string]int64 // number of messages sent, by kind
+
+	metrics          *metrics.Registry // exposes the metrics of the process (optional)
+	messagesSent     *metrics.CounterVec
+	messagesReceived *metrics.CounterVec
+	messagesRejected *metrics.Counter
+	csEntries        *metrics.Counter
+	waitTime         *metrics.Histogram
+	snapshotDuration *metrics.Histogram
+	wantSince        time.Time // when the pending request to the CS was issued
+	snapshotSince    time.Time // when the last snapshot was taken by this process
+
+	ctl chan func() // funcoes de controle (admin) executadas pelo laco de eventos, uma por vez
+
+	observers []Observer // notificados dos eventos do protocolo, pelo laco de eventos
+
+	detector  *failuredetector.Detector // detector de falhas (opcional)
+	fdCfg     *failuredetector.Config
+	suspected []bool // processos suspeitos: excluidos do quorum e do broadcast de reqEntry
+	requested []bool // processos para os quais o reqEntry do pedido corrente foi enviado
+	granted   []bool // processos que responderam (respOk) ao pedido corrente
+
+	wal            *wal.Log     // log de escrita antecipada do estado (opcional)
+	recovering     bool         // recuperando de uma falha: nao atende a aplicacao nem responde reqEntry
+	recoveryTicker *time.Ticker // reenvio do recover aos processos que ainda nao responderam
+	recoverAcks    []bool       // processos que responderam ao recover
+	recoverReqs    []bool       // processos com pedido pendente, respondidos ao fim da recuperacao
+
+	view    int               // numero da visao corrente do grupo (0: composicao inicial)
+	members []bool            // processos que fazem parte do grupo na visao corrente
+	backlog []pp2plink.IndMsg // mensagens recebidas antes de entrar no grupo ou de processos fora da visao
+	left    chan struct{}     // fechado quando o processo sai do grupo
+
+	lease         time.Duration    // duracao do lease da SC (0: sem lease)
+	leaseTimer    *time.Timer      // expiracao do lease da SC corrente (nil fora da SC)
+	leaseDeadline time.Time        // quando o lease da SC corrente expira
+	leaseExpired  bool             // o lease expirou e a aplicacao ainda nao enviou os EXIT
+	leasesExpired *metrics.Counter // SCs liberadas pela expiracao do lease
+
+	token fencing.Token // maior token de fencing conhecido (da ultima entrada ou recebido nos respOk)
+
+	authKey []byte      // chave compartilhada do HMAC das mensagens (nil: sem autenticacao)
+	tlsCfg  *tls.Config // configuracao das conexoes com TLS (nil: conexoes sem TLS)
+
+	reliable bool // entrega confiavel das mensagens pelo link (confirmacoes e retransmissoes)
+
+	log     *logrus.Entry // logger do DIMEX, com o PID do processo
+	snapLog *logrus.Entry // logger dos snapshots, com o PID do processo
+	linkLog *logrus.Entry // logger do PP2PLink, com o PID do processo
 }
 
 // ------------------------------------------------------------------------------------
@@ -77,7 +181,9 @@ type Dimex struct {
 // ------------------------------------------------------------------------------------
 
 // WithFailOpt is an option to enable failure simulation for the DIMEX module
-// and trigger snapshot invariant violations.
+// and trigger snapshot invariant violations: the process grants its permission to every request
+// right away, even while it is in the CS or its own request comes first, so other processes enter
+// the CS together with it.
 func WithFailOpt() Opt {
 	return func(m *Dimex) {
 		m.fail = true
@@ -92,38 +198,211 @@ func WithSnapshotIntervalOpt(intervalSec float64) Opt {
 	}
 }
 
+// WithCollectorOpt is an option to hand each completed snapshot over to a collector,
+// which verifies the snapshots online while the system is running.
+func WithCollectorOpt(collector *snapshots.Collector) Opt {
+	return func(m *Dimex) {
+		m.collector = collector
+	}
+}
+
+// WithTracerOpt is an option to record the protocol events (entry requests, critical section
+// intervals, snapshots, and sending and receiving of messages) with the given tracer.
+func WithTracerOpt(tracer *trace.Tracer) Opt {
+	return func(m *Dimex) {
+		m.tracer = tracer
+	}
+}
+
+// WithMetricsOpt is an option to instrument the DIMEX module (and its PP2PLink) with counters
+// and histograms in the given registry: messages sent and received by kind, CS entries, wait
+// time for the CS and snapshot duration.
+func WithMetricsOpt(registry *metrics.Registry) Opt {
+	return func(m *Dimex) {
+		m.metrics = registry
+		m.messagesSent = registry.CounterVec("dimex_messages_sent_total", "Messages sent to other processes (and to itself), by kind.", "kind")
+		m.messagesReceived = registry.CounterVec("dimex_messages_received_total", "Messages received from other processes (and from itself), by kind.", "kind")
+		m.messagesRejected = registry.Counter("dimex_messages_rejected_total", "Messages with a malformed PID or not sent by the process they claim, per its certificate.")
+		m.csEntries = registry.Counter("dimex_cs_entries_total", "Entries in the critical section.")
+		m.waitTime = registry.Histogram("dimex_cs_wait_seconds", "Time between a request to the critical section and the entry in it.", metrics.DefaultBuckets)
+		m.leasesExpired = registry.Counter("dimex_cs_leases_expired_total", "Critical sections released because their lease expired.")
+		m.snapshotDuration = registry.Histogram("dimex_snapshot_duration_seconds", "Time between taking a snapshot and completing it (all channels closed).", metrics.DefaultBuckets)
+	}
+}
+
+// WithFailureDetectorOpt is an option to detect crashed processes with a heartbeat-based failure
+// detector. The suspected processes are recorded in the snapshots. With the Perfect mode, they are
+// also excluded from the quorum of permissions a request waits for and from the processes the
+// requests are sent to. The suspicions of the EventuallyPerfect mode may be false, and excusing a
+// process that is alive (e.g., in the CS) would break mutual exclusion, so in that mode a request
+// still waits for the suspected processes: a crashed process blocks the others, as without a
+// failure detector.
+func WithFailureDetectorOpt(cfg failuredetector.Config) Opt {
+	return func(m *Dimex) {
+		m.fdCfg = &cfg
+	}
+}
+
+// WithWALOpt is an option to persist the Lamport clock, the pending request and the deferred
+// replies of the process in the given write-ahead log. If the log recorded a state in a previous
+// run, the process recovers from it, resynchronizing with the other processes before it answers
+// any request.
+func WithWALOpt(l *wal.Log) Opt {
+	return func(m *Dimex) {
+		m.wal = l
+	}
+}
+
+// WithAuthKeyOpt is an option to authenticate the messages exchanged with the other processes with
+// an HMAC of the given key, shared by all processes (see pp2plink.WithHMACOpt). Forged and replayed
+// messages are rejected by the link, so neither the algorithm nor the snapshots ever see them.
+func WithAuthKeyOpt(key []byte) Opt {
+	return func(m *Dimex) {
+		m.authKey = key
+	}
+}
+
+// WithTLSOpt is an option to run the connections with the other processes over TLS with the given
+// configuration (see pp2plink.WithTLSOpt and pp2plink.LoadTLSConfig). With mutual TLS, the identity
+// of a process is the address in its certificate, and the messages whose PID is not the one of the
+// process that sent them are rejected.
+func WithTLSOpt(cfg *tls.Config) Opt {
+	return func(m *Dimex) {
+		m.tlsCfg = cfg
+	}
+}
+
+// WithReliableOpt is an option to exchange the messages with the other processes with reliable
+// delivery (see pp2plink.WithReliableOpt): each message is retransmitted until it is acknowledged,
+// even when a connection fails, and delivered once and in FIFO order, and sending a message never
+// blocks the module. Messages are still lost when more than 1024 to a process are not acknowledged
+// (e.g., it crashed): the oldest ones are dropped. Heartbeats are sent only once, since the next
+// one replaces a lost one.
+func WithReliableOpt() Opt {
+	return func(m *Dimex) {
+		m.reliable = true
+	}
+}
+
+// WithLoggerOpt is an option to log the events of the DIMEX module with the given logger
+// instead of the standard logger. The PID of the process is added to every entry.
+func WithLoggerOpt(logger *logrus.Entry) Opt {
+	return func(m *Dimex) {
+		m.log = logger
+	}
+}
+
+// WithSnapshotLoggerOpt is an option to log the snapshots taken by the DIMEX module with the
+// given logger instead of the standard logger. The PID of the process and the snapshot ID are
+// added to every entry.
+func WithSnapshotLoggerOpt(logger *logrus.Entry) Opt {
+	return func(m *Dimex) {
+		m.snapLog = logger
+	}
+}
+
+// WithLinkLoggerOpt is an option to log the events of the PP2PLink of the DIMEX module with the
+// given logger instead of the standard logger. The PID of the process is added to every entry.
+func WithLinkLoggerOpt(logger *logrus.Entry) Opt {
+	return func(m *Dimex) {
+		m.linkLog = logger
+	}
+}
+
 // ------------------------------------------------------------------------------------
 // ------- inicializacao
 // ------------------------------------------------------------------------------------
 
 func NewDimex(_addresses []string, _id int, opts ...Opt) *Dimex {
-	p2p := pp2plink.NewPP2PLink(_addresses[_id], false)
+	dmx := newDimex(opts...)
+	dmx.linkLog = dmx.linkLog.WithField("pid", _id)
+	dmx.Pp2plink = dmx.newLink(_addresses[_id])
+
+	members := make([]bool, len(_addresses))
+	for i := range members {
+		members[i] = true
+	}
+	dmx.init(_addresses, _id, 0, members)
+
+	if dmx.wal != nil && dmx.wal.Recovered() != nil {
+		dmx.restore(*dmx.wal.Recovered())
+	}
+
+	dmx.Start()
+	dmx.outDbg("Init DIMEX!")
 
+	return dmx
+}
+
+// newDimex creates a DIMEX module with the given options, which is not yet part of a group.
+func newDimex(opts ...Opt) *Dimex {
 	dmx := &Dimex{
-		Req: make(chan dmxReq, 1),
-		Ind: make(chan dmxResp, 1),
+		Req:  make(chan dmxReq, 1),
+		Ind:  make(chan dmxResp, 1),
+		ctl:  make(chan func()),
+		left: make(chan struct{}),
 
-		addresses:           _addresses,
-		id:                  _id,
 		st:                  common.NoMX,
-		waiting:             make([]bool, len(_addresses)),
+		aging:               DefaultAgingTicks,
 		lcl:                 0,
 		reqTs:               0,
-		dbg:                 false,
 		fail:                false,
 		snapshotIntervalSec: 1.0,
 
-		Pp2plink: p2p,
+		sent: make(map[string]int64),
+
+		log:     logrus.NewEntry(logrus.StandardLogger()),
+		snapLog: logrus.NewEntry(logrus.StandardLogger()),
+		linkLog: logrus.NewEntry(logrus.StandardLogger()),
 	}
 
 	for _, opt := range opts {
 		opt(dmx)
 	}
+	return dmx
+}
 
-	dmx.Start()
-	dmx.outDbg("Init DIMEX!")
+// newLink creates the link of the module, listening on the given address.
+func (m *Dimex) newLink(address string) *pp2plink.PP2PLink {
+	linkOpts := []pp2plink.Opt{pp2plink.WithLoggerOpt(m.linkLog)}
+	if m.tracer != nil {
+		linkOpts = append(linkOpts, pp2plink.WithTracerOpt(m.tracer))
+	}
+	if m.metrics != nil {
+		linkOpts = append(linkOpts, pp2plink.WithMetricsOpt(m.metrics))
+	}
+	if m.authKey != nil {
+		linkOpts = append(linkOpts, pp2plink.WithHMACOpt(m.authKey))
+	}
+	if m.tlsCfg != nil {
+		linkOpts = append(linkOpts, pp2plink.WithTLSOpt(m.tlsCfg))
+	}
+	if m.reliable {
+		linkOpts = append(linkOpts, pp2plink.WithReliableOpt())
+	}
+	return pp2plink.NewPP2PLink(address, linkOpts...)
+}
 
-	return dmx
+// init makes the module the process with the given PID in the given view of the group.
+func (m *Dimex) init(addresses []string, id int, view int, members []bool) {
+	m.addresses = addresses
+	m.id = id
+	m.view = view
+	m.members = members
+	m.waiting = make([]bool, len(addresses))
+	m.suspected = make([]bool, len(addresses))
+	m.requested = make([]bool, len(addresses))
+	m.granted = make([]bool, len(addresses))
+
+	m.log = m.log.WithField("pid", id)
+	m.snapLog = m.snapLog.WithField("pid", id)
+
+	if m.fdCfg != nil {
+		m.detector = failuredetector.NewDetector(id, len(addresses), *m.fdCfg, func(to int) {
+			m.sendToLink(m.address(to), fmt.Sprintf("%s;%d", HEARTBEAT, id))
+		})
+		m.detector.SetMembers(members)
+	}
 }
 
 // ------------------------------------------------------------------------------------
@@ -131,35 +410,46 @@ func NewDimex(_addresses []string, _id int, opts ...Opt) *Dimex {
 // ------------------------------------------------------------------------------------
 
 func (m *Dimex) Start() {
+	var fdInd chan failuredetector.Event // nil (bloqueia para sempre) sem detector de falhas
+	if m.detector != nil {
+		fdInd = m.detector.Ind
+		m.detector.Start()
+	}
+
 	go func() {
+		if m.recovering {
+			m.sendRecover()
+		}
+		m.replayBacklog() // recebidas antes de o processo entrar no grupo
+
 		for {
+			req := m.Req
+			var retry <-chan time.Time // nil (bloqueia para sempre) fora da recuperacao
+			if m.recovering {
+				req = nil // pedidos da aplicacao aguardam o fim da recuperacao
+				retry = m.recoveryTicker.C
+			}
+
 			select {
-			case dmxR := <-m.Req: // vindo da  aplicação
-				if dmxR == ENTER {
+			case dmxR := <-req: // vindo da  aplicação
+				if dmxR == ENTER || dmxR == ENTER_SHARED {
 					m.outDbg("app pede mx")
-					m.handleUponReqEntry()
+					m.handleUponReqEntry(dmxR == ENTER_SHARED)
 
 				} else if dmxR == EXIT {
 					m.outDbg("app libera mx")
-					m.handleUponReqExit()
+					m.handleExit()
 				}
 			case msgOutro := <-m.Pp2plink.Ind: // vindo de outro processo
-				if strings.Contains(msgOutro.Message, SNAP) {
-					m.outDbg("         <<<---- snap!")
-					m.handleIncomingSnap(
-						m.messagesMiddleware(msgOutro),
-					)
-				} else if strings.Contains(msgOutro.Message, RESP_OK) {
-					m.outDbg("         <<<---- responde! " + msgOutro.Message)
-					m.handleUponDeliverRespOk(
-						m.messagesMiddleware(msgOutro),
-					)
-				} else if strings.Contains(msgOutro.Message, REQ_ENTRY) {
-					m.outDbg("          <<<---- pede??  " + msgOutro.Message)
-					m.handleUponDeliverReqEntry(
-						m.messagesMiddleware(msgOutro),
-					)
-				}
+				m.handleMessage(msgOutro)
+			case fn := <-m.ctl: // vindo da administracao
+				fn()
+			case ev := <-fdInd: // vindo do detector de falhas
+				m.handleSuspicion(ev)
+			case <-retry: // recuperacao em andamento
+				m.sendRecover()
+			case <-m.leaseC(): // lease da SC expirou
+				m.handleLeaseExpired()
 			}
 		}
 	}()
@@ -169,24 +459,145 @@ func (m *Dimex) Start() {
 		ticker := time.NewTicker(time.Duration(m.snapshotIntervalSec * float64(time.Second)))
 		defer ticker.Stop()
 		for t := range ticker.C {
-			turn := (t.UnixNano() / int64(m.snapshotIntervalSec*float64(time.Second))) % int64(len(m.addresses))
-			if int(turn) != m.id {
-				continue
-			}
+			m.control(func() {
+				turn := (t.UnixNano() / int64(m.snapshotIntervalSec*float64(time.Second))) % int64(len(m.addresses))
+				if int(turn) == m.id && m.isMember(m.id) {
+					m.initiateSnapshot()
+				}
+			})
+		}
+	}()
+}
 
-			snapId := 0
-			if m.lastSnapshot != nil {
-				snapId = m.lastSnapshot.ID + 1
+// handleMessage dispatches a message from another process to its handler.
+func (m *Dimex) handleMessage(msgOutro pp2plink.IndMsg) {
+	if pid, ok := senderPID(msgOutro.Message); !ok {
+		m.log.WithField("from", msgOutro.From).Warn("message rejected: malformed PID: " + msgOutro.Message)
+		m.messagesRejected.Inc()
+		return
+	} else if pid >= len(m.addresses) {
+		// de um processo que entrou no grupo numa visao que este processo ainda nao instalou
+		m.deferMessage(msgOutro)
+		return
+	}
+	if !m.authentic(msgOutro) {
+		m.log.WithFields(logrus.Fields{"peer": msgOutro.Peer, "from": msgOutro.From}).Warn("message rejected: not sent by the process it claims: " + msgOutro.Message)
+		m.messagesRejected.Inc()
+		return
+	}
+	kind := common.MessageKind(msgOutro.Message)
+	m.messagesReceived.Inc(kind)
+	log := m.log.WithFields(logrus.Fields{"kind": kind, "from": msgOutro.From})
+	if kind == HEARTBEAT { // tratado pelo detector de falhas, fora dos snapshots
+		m.handleHeartbeat(msgOutro)
+	} else if kind == RECOVER { // tratados pela recuperacao, fora dos snapshots
+		m.handleRecover(msgOutro)
+	} else if kind == RECOVER_ACK {
+		m.handleRecoverAck(msgOutro)
+	} else if kind == JOIN { // tratados pela composicao do grupo, fora dos snapshots
+		m.handleJoin(msgOutro)
+	} else if kind == LEAVE {
+		m.handleLeave(msgOutro)
+	} else if kind == VIEW {
+		m.handleView(msgOutro)
+	} else if strings.Contains(msgOutro.Message, SNAP) {
+		log.Debug("<<<---- snap! " + msgOutro.Message)
+		m.handleIncomingSnap(
+			m.messagesMiddleware(msgOutro),
+		)
+	} else if strings.Contains(msgOutro.Message, RESP_OK) {
+		log.Debug("<<<---- responde! " + msgOutro.Message)
+		m.handleUponDeliverRespOk(
+			m.messagesMiddleware(msgOutro),
+		)
+	} else if strings.Contains(msgOutro.Message, REQ_ENTRY) {
+		log.Debug("<<<---- pede?? " + msgOutro.Message)
+		m.handleUponDeliverReqEntry(
+			m.messagesMiddleware(msgOutro),
+		)
+	}
+}
+
+// ------------------------------------------------------------------------------------
+// ------- inspecao e controle (admin)
+// ------- executados pelo laco de eventos, como os demais eventos
+// ------------------------------------------------------------------------------------
+
+// Inspect returns the current state of the process.
+func (m *Dimex) Inspect() Status {
+	var status Status
+	m.control(func() {
+		waiting := make([]bool, len(m.waiting))
+		copy(waiting, m.waiting)
+		status = Status{
+			PID:        m.id,
+			State:      m.st.String(),
+			LocalClock: m.lcl,
+			ReqTs:      m.reqTs,
+			Waiting:    waiting,
+			NbrResps:   m.nbrResps,
+			Fail:       m.fail,
+			Suspected:  m.suspectedCopy(),
+			Recovering: m.recovering,
+			Shared:     m.shared,
+			Priority:   m.reqPriority,
+			Holds:      m.holds,
+			View:       m.view,
+			Members:    m.membersCopy(),
+		}
+	})
+	return status
+}
+
+// Peers returns the other processes of the system, with the status of the connection to each one.
+func (m *Dimex) Peers() []Peer {
+	var peers []Peer
+	m.control(func() {
+		peers = make([]Peer, 0, len(m.addresses)-1)
+		for i, addr := range m.addresses {
+			if i != m.id {
+				peers = append(peers, Peer{
+					PID:       i,
+					Address:   addr,
+					Connected: m.Pp2plink.Connected(addr),
+					Suspected: m.suspected[i],
+					Member:    m.isMember(i),
+				})
 			}
-			logrus.Infof("=========== P%d initiating a snapshot (SNAPID %d) ===========\n", m.id, snapId)
-			// send a snapshot message to myself
-			m.sendToLink(
-				m.addresses[m.id],
-				fmt.Sprintf("%s;%d;%d", SNAP, m.id, snapId),
-				fmt.Sprintf("PID %d", m.id),
-			)
 		}
-	}()
+	})
+	return peers
+}
+
+// LastSnapshot returns the last snapshot completed by the process, or nil if there is none.
+func (m *Dimex) LastSnapshot() *snapshots.Snapshot {
+	var snapshot *snapshots.Snapshot
+	m.control(func() { snapshot = m.lastCompletedSnap })
+	return snapshot
+}
+
+// TriggerSnapshot initiates a snapshot right away, instead of waiting for the turn of the process,
+// and returns its ID.
+func (m *Dimex) TriggerSnapshot() int {
+	var snapId int
+	m.control(func() { snapId = m.initiateSnapshot() })
+	return snapId
+}
+
+// SetFail enables or disables the failure simulation at runtime (see WithFailOpt).
+func (m *Dimex) SetFail(fail bool) {
+	m.control(func() { m.fail = fail })
+}
+
+// control runs fn in the event loop, so it is atomic with respect to the other events, and
+// waits for it to finish.
+func (m *Dimex) control(fn func()) {
+	done := make(chan struct{})
+	m.ctl <- func() {
+		fn()
+		close(done)
+	}
+	<-done
 }
 
 // ------------------------------------------------------------------------------------
@@ -196,29 +607,95 @@ func (m *Dimex) Start() {
 // ------------------------------------------------------------------------------------
 
 /*
-upon event [ dmx, Entry  |  r ]  do
+upon event [ dmx, Entry  |  r, modo ]  do
 
 	lts.ts++
+	minhaPrio := prioridade
 	myTs := lts
+	meuModo := modo  // compartilhado (leitor) ou exclusivo (escritor)
 	resps := 0
 	para todo processo p
-		trigger [ pl , Send | [ reqEntry, r, myTs ]
+		trigger [ pl , Send | [ reqEntry, r, myTs, meuModo, minhaPrio ]
 	estado := queroSC
 */
-func (m *Dimex) handleUponReqEntry() {
+func (m *Dimex) handleUponReqEntry(shared bool) {
+	if !m.isMember(m.id) {
+		m.log.Error("request to the CS ignored: the process is not in the group")
+		return
+	}
+	if m.st != common.NoMX {
+		m.handleNestedEntry(shared)
+		return
+	}
+	m.leaseExpired = false // a aplicacao nao enviou os EXIT da SC expirada
+	m.holds = 0
 	m.lcl++
+	m.reqPriority = m.priority
 	m.reqTs = m.lcl
+	m.shared = shared
 	m.nbrResps = 0
+	m.requested = make([]bool, len(m.addresses))
+	m.granted = make([]bool, len(m.addresses))
+	m.st = common.WantMX
+	m.persist()
 	for i := 0; i < len(m.addresses); i++ {
-		if i != m.id {
-			m.sendToLink(
-				m.addresses[i],
-				fmt.Sprintf("%s;%d;%d", REQ_ENTRY, m.id, m.reqTs),
-				fmt.Sprintf("PID %d", m.id),
-			)
+		if i != m.id && m.isMember(i) && !m.excluded(i) { // processos suspeitos (detector perfeito) ficam fora do broadcast
+			m.sendReqEntry(i)
 		}
 	}
-	m.st = common.WantMX
+	m.wantSince = time.Now()
+	if m.tracer != nil {
+		m.tracer.WantCS(m.reqTs)
+	}
+	m.notify(func(o Observer) { o.RequestSent(m.id, m.reqTs) })
+	m.tryEnterCS() // todos os outros processos podem ser suspeitos
+}
+
+/*
+upon event [ dmx, Entry  |  r, modo ]  com estado != naoQueroSC  do
+
+	se estado == queroSC
+	então  ignora  // pedido repetido: a indicacao e do pedido pendente
+	senão se modo == compartilhado OR meuModo == exclusivo
+	então  entradas++  // reentrada: a SC ja e deste processo
+		trigger [ dmx, Deliver | free2Access, meuToken ]
+	senão  trigger [ dmx, Deliver | erro ]  // leitor querendo virar escritor
+*/
+func (m *Dimex) handleNestedEntry(shared bool) {
+	switch {
+	case m.st == common.WantMX:
+		// o canal Ind e do pedido pendente, cuja aplicacao espera a entrada: uma indicacao de erro
+		// seria tomada como a resposta do pedido, e a da entrada bloquearia o laco de eventos
+		m.log.WithError(ErrAlreadyRequested).Error("request to the CS ignored")
+	case shared || !m.shared: // quem escreve tambem pode ler
+		m.holds++
+		m.Ind <- dmxResp{Token: m.token}
+	default:
+		m.log.WithError(ErrUpgrade).Error("request to the CS rejected")
+		m.Ind <- dmxResp{Err: fmt.Errorf("dimex.handleNestedEntry: process %v: %w", m.st, ErrUpgrade)}
+	}
+}
+
+/*
+upon event [ dmx, Exit  |  r  ]  do  // com entradas aninhadas
+
+	entradas--
+	se entradas == 0
+	então  libera a SC (abaixo)
+*/
+func (m *Dimex) handleExit() {
+	if m.holds == 0 {
+		m.log.Error("exit from the CS ignored: the process is not in the CS")
+		return
+	}
+	m.holds--
+	if m.leaseExpired { // a SC ja foi liberada quando o lease expirou
+		m.leaseExpired = m.holds > 0
+		return
+	}
+	if m.holds == 0 {
+		m.handleUponReqExit()
+	}
 }
 
 /*
@@ -230,17 +707,23 @@ upon event [ dmx, Exit  |  r  ]  do
 	waiting := {}
 */
 func (m *Dimex) handleUponReqExit() {
-	for i := 0; i < len(m.waiting); i++ {
-		if m.waiting[i] && i != m.id {
+	m.stopLease()
+	waiting := m.waiting
+	m.st = common.NoMX
+	m.waiting = make([]bool, len(m.addresses))
+	m.persist()
+	for i := 0; i < len(waiting); i++ {
+		if waiting[i] && i != m.id {
 			m.sendToLink(
 				m.addresses[i],
-				fmt.Sprintf("%s;%d", RESP_OK, m.id),
-				fmt.Sprintf("PID %d", m.id),
+				m.respOk(),
 			)
 		}
 	}
-	m.st = common.NoMX
-	m.waiting = make([]bool, len(m.addresses))
+	if m.tracer != nil {
+		m.tracer.ExitCS()
+	}
+	m.notify(func(o Observer) { o.CSExited(m.id) })
 }
 
 // ------------------------------------------------------------------------------------
@@ -250,31 +733,95 @@ func (m *Dimex) handleUponReqExit() {
 // ------------------------------------------------------------------------------------
 
 /*
-upon event [ pl, Deliver | p, [ respOk, r ] ]
+upon event [ pl, Deliver | p, [ respOk, r, token ] ]
 
+	meuToken := max(meuToken, token)
+	se estado != queroSC ou p ja respondeu
+	então ignora (permissao repetida)
 	resps++
 	se resps = N
-	então trigger [ dmx, Deliver | free2Access ]
+	então meuToken := meuToken + 1  // nova epoca se dispensou algum processo suspeito
+		trigger [ dmx, Deliver | free2Access, meuToken ]
 		estado := estouNaSC
 */
 func (m *Dimex) handleUponDeliverRespOk(msgOutro pp2plink.IndMsg) {
+	parts := strings.Split(msgOutro.Message, ";")
+	otherId, _ := strconv.Atoi(parts[1])
+	if len(parts) > 2 {
+		token, _ := strconv.ParseInt(parts[2], 10, 64)
+		if fencing.Token(token) > m.token {
+			m.token = fencing.Token(token)
+		}
+	}
+	if m.st != common.WantMX || m.granted[otherId] {
+		// permissao repetida (p.ex., reenviada na recuperacao de um processo que caiu enquanto a
+		// original estava em transito) ou fora de pedido: contar de novo dispensaria a de outro processo
+		m.log.WithField("from", otherId).Debug("respOk repetido ignorado")
+		return
+	}
 	m.nbrResps++
+	m.granted[otherId] = true
+	m.notify(func(o Observer) { o.PermissionReceived(m.id, otherId, m.nbrResps) })
+
+	m.tryEnterCS()
+}
 
-	if m.nbrResps == len(m.addresses)-1 {
+// tryEnterCS enters the CS if the process wants it and has the permissions of all other members of
+// the group, except the ones excluded by the perfect failure detector that have not given theirs.
+// The permissions of processes that left the group are not counted.
+func (m *Dimex) tryEnterCS() {
+	needed, excused, departed := 0, 0, 0
+	for i := range m.addresses {
+		if i == m.id {
+			continue
+		}
+		if !m.isMember(i) {
+			if m.granted[i] {
+				departed++
+			}
+			continue
+		}
+		needed++
+		if m.excluded(i) && !m.granted[i] {
+			excused++
+		}
+	}
+
+	if m.st == common.WantMX && m.nbrResps-departed+excused >= needed {
 		m.st = common.InMX
-		m.Ind <- dmxResp{}
+		m.persist()
+		m.csEntries.Inc()
+		m.waitTime.Observe(time.Since(m.wantSince).Seconds())
+		if m.tracer != nil {
+			m.tracer.EnterCS()
+		}
+		m.notify(func(o Observer) { o.CSEntered(m.id) })
+		m.startLease()
+		if excused > 0 {
+			// o processo dispensado pode estar na SC com um token que nao repassou: nova epoca
+			m.token = m.token.NextEpoch(m.id)
+		} else {
+			m.token = m.token.Next(m.id)
+		}
+		m.holds = 1
+		m.Ind <- dmxResp{Token: m.token}
 	}
 }
 
 /*
-upon event [ pl, Deliver | p, [ reqEntry, r, rts ]  do
+upon event [ pl, Deliver | p, [ reqEntry, r, rts, modo, prio ]  do
 
 	se (estado == naoQueroSC)   OR
-			(estado == QueroSC AND  myTs >  ts)
+			(meuModo == modo == compartilhado)   OR
+			(estado == QueroSC AND  [myTs, minhaPrio] depois de [ts, prio])   OR
+			falha simulada
 	então  trigger [ pl, Send | p , [ respOk, r ]  ]
+		se estado == QueroSC AND NOT (meuModo == modo == compartilhado) AND p ja respondeu
+		então  resps--  // p respondeu antes de pedir, e o pedido dele passa na frente (prioridade)
+			trigger [ pl, Send | p, [ reqEntry, r, myTs, meuModo, minhaPrio ] ]  // p adia ate sair da SC
 	senão
 		se (estado == estouNaSC) OR
-				(estado == QueroSC AND  myTs < ts)
+				(estado == QueroSC AND  [myTs, minhaPrio] antes de [ts, prio])
 		então  postergados := postergados + [p, r ]
 		lts.ts := max(lts.ts, rts.ts)
 */
@@ -282,27 +829,38 @@ func (m *Dimex) handleUponDeliverReqEntry(msgOutro pp2plink.IndMsg) {
 	parts := strings.Split(msgOutro.Message, ";")
 	otherId, _ := strconv.Atoi(parts[1])
 	otherReqTs, _ := strconv.Atoi(parts[2])
+	otherShared := len(parts) > 3 && parts[3] == common.SHARED
+	otherPriority := 0
+	if len(parts) > 4 {
+		otherPriority, _ = strconv.Atoi(parts[4])
+	}
+	readers := m.st != common.NoMX && m.shared && otherShared // leitores nao conflitam
+
+	if m.recovering {
+		// respondido ao fim da recuperacao, quando o estado estiver sincronizado
+		m.recoverReqs[otherId] = true
+		m.lcl = max(m.lcl, otherReqTs)
+		return
+	}
 
-	if m.st == common.NoMX || (m.st == common.WantMX && after(m.reqTs, m.id, otherReqTs, otherId)) {
+	yields := m.st == common.WantMX && m.yields(otherReqTs, otherId, otherPriority)
+	// simulate process failure by granting the permission it should delay, to trigger snapshot
+	// invariant check failures
+	if m.st == common.NoMX || readers || yields || m.fail {
+		m.lcl = max(m.lcl, otherReqTs)
+		m.persist()
 		m.sendToLink(
 			m.addresses[otherId],
-			fmt.Sprintf("%s;%d", RESP_OK, m.id),
-			fmt.Sprintf("PID %d", m.id),
+			m.respOk(),
 		)
-
-		if m.fail {
-			// simulate process failure by sending duplicate RESP_OK to trigger snapshot invariant check failures
-			m.sendToLink(
-				m.addresses[otherId],
-				fmt.Sprintf("%s;%d", RESP_OK, m.id),
-				fmt.Sprintf("PID %d", m.id),
-			)
+		if yields && !readers && m.granted[otherId] {
+			m.revokePermission(otherId)
 		}
 	} else {
 		m.waiting[otherId] = true
+		m.lcl = max(m.lcl, otherReqTs)
+		m.persist()
 	}
-
-	m.lcl = max(m.lcl, otherReqTs)
 }
 
 func (m *Dimex) handleIncomingSnap(msg pp2plink.IndMsg) {
@@ -312,33 +870,208 @@ func (m *Dimex) handleIncomingSnap(msg pp2plink.IndMsg) {
 
 	takeSnapshot := m.lastSnapshot == nil || m.lastSnapshot.ID < snapId
 	if takeSnapshot {
-		logrus.Debugf("\t\tP%d: taking snapshot %d\n", m.id, snapId)
+		m.snapLog.WithField("snapId", snapId).Debug("taking snapshot")
 		m.takeSnapshot(snapId)
 	}
 
-	m.lastSnapshot.CommunicationChans[senderId].Close()
+	m.lastSnapshot.CloseChan(senderId)
+	m.completeSnapshot()
+}
+
+// ------------------------------------------------------------------------------------
+// ------- tratamento de eventos do detector de falhas
+// ------- UPON heartbeat
+// ------- UPON suspect / restore
+// ------------------------------------------------------------------------------------
 
-	if m.lastSnapshot.IsOver() {
-		logrus.Debugf("\t\tP%d: snapshot %d completed. Dumping to file...\n", m.id, snapId)
-		if err := m.lastSnapshot.DumpToFile(); err != nil {
-			logrus.Errorf("\t\tP%d: error dumping snapshot %d to file: %v\n", m.id, snapId, err)
+func (m *Dimex) handleHeartbeat(msg pp2plink.IndMsg) {
+	otherId, _ := strconv.Atoi(strings.Split(msg.Message, ";")[1])
+	if m.detector != nil {
+		m.detector.Heartbeat(otherId)
+	}
+}
+
+/*
+upon event [ fd, Suspect | p ]  do
+
+	suspeitos := suspeitos + [p]
+	canal de p no snapshot corrente := fechado
+	se detector perfeito e estado == queroSC e respostas de todos os nao suspeitos
+	então trigger [ dmx, Deliver | free2Access ]  // com <>P a suspeita pode ser falsa: espera p
+
+upon event [ fd, Restore | p ]  do
+
+	suspeitos := suspeitos - [p]
+	se estado == queroSC e p nao recebeu reqEntry
+	então trigger [ pl, Send | p, [ reqEntry, r, myTs ] ]
+*/
+func (m *Dimex) handleSuspicion(ev failuredetector.Event) {
+	m.suspected[ev.PID] = ev.Suspected
+	m.log.WithFields(logrus.Fields{"process": ev.PID, "suspected": ev.Suspected}).Warn("failure detector changed suspicion")
+
+	if !ev.Suspected {
+		// o processo volta ao quorum; se o pedido corrente nao foi enviado a ele, envia agora
+		if m.st == common.WantMX && !m.requested[ev.PID] {
+			m.sendReqEntry(ev.PID)
 		}
+		return
+	}
+
+	// um processo que falhou nao envia o SNAP: o canal dele no snapshot corrente e fechado
+	if m.lastSnapshot != nil && m.lastCompletedSnap != m.lastSnapshot {
+		m.lastSnapshot.Suspected[ev.PID] = true
+		m.lastSnapshot.CloseChan(ev.PID)
+		m.completeSnapshot()
 	}
+	m.tryFinishRecovery() // o processo suspeito nao responde ao recover
+	m.tryEnterCS()
 }
 
 // ------------------------------------------------------------------------------------
 // ------- funcoes de ajuda
 // ------------------------------------------------------------------------------------
 
-func (m *Dimex) sendToLink(address string, content string, space string) {
-	m.outDbg(space + " ---->>>>   to: " + address + "     msg: " + content)
+// excluded tells whether the process with the given PID is left out of the quorum of permissions:
+// only the suspicions of the perfect failure detector are trusted (see WithFailureDetectorOpt).
+func (m *Dimex) excluded(pid int) bool {
+	return m.suspected[pid] && m.fdCfg != nil && m.fdCfg.Mode == failuredetector.Perfect
+}
+
+// completeSnapshot dumps the current snapshot and hands it over to the collector and the observers,
+// if all of its channels are closed and it has not been completed yet.
+func (m *Dimex) completeSnapshot() {
+	if !m.lastSnapshot.IsOver() || m.lastCompletedSnap == m.lastSnapshot {
+		return
+	}
+	snapId := m.lastSnapshot.ID
+
+	m.snapLog.WithField("snapId", snapId).Debug("snapshot completed. Dumping to file...")
+	m.snapshotDuration.Observe(time.Since(m.snapshotSince).Seconds())
+	if err := m.lastSnapshot.DumpToFile(); err != nil {
+		m.snapLog.WithField("snapId", snapId).WithError(err).Error("error dumping snapshot to file")
+	}
+	m.lastCompletedSnap = m.lastSnapshot
+	if m.tracer != nil {
+		m.tracer.Local(fmt.Sprintf("snapshot %d completed", snapId))
+	}
+	snapshot := m.lastSnapshot
+	m.notify(func(o Observer) { o.SnapshotCompleted(m.id, snapshot) })
+	if m.collector != nil {
+		// violations are logged by the collector itself
+		if err := m.collector.Submit(*m.lastSnapshot); err != nil {
+			m.notify(func(o Observer) { o.InvariantViolated(m.id, snapId, err) })
+		}
+	}
+}
+
+func (m *Dimex) sendReqEntry(to int) {
+	m.requested[to] = true
+	m.sendToLink(
+		m.addresses[to],
+		fmt.Sprintf("%s;%d;%d;%s;%d", REQ_ENTRY, m.id, m.reqTs, mode(m.shared), m.reqPriority),
+	)
+}
+
+// respOk returns the permission to enter the CS, with the highest fencing token known by the
+// process, so the next entry in the CS takes a token above it.
+func (m *Dimex) respOk() string {
+	return fmt.Sprintf("%s;%d;%d", RESP_OK, m.id, int64(m.token))
+}
+
+// persist records the state of the process in the write-ahead log (if any). It must be called
+// before sending the messages that depend on the state.
+func (m *Dimex) persist() {
+	if m.wal == nil {
+		return
+	}
+	waiting := make([]bool, len(m.waiting))
+	copy(waiting, m.waiting)
+	state := wal.State{LocalClock: m.lcl, State: m.st, ReqTs: m.reqTs, Waiting: waiting}
+	if err := m.wal.Append(state); err != nil {
+		m.log.WithError(err).Error("error persisting state")
+	}
+}
+
+func (m *Dimex) suspectedCopy() []bool {
+	if m.detector == nil {
+		return nil
+	}
+	suspected := make([]bool, len(m.suspected))
+	copy(suspected, m.suspected)
+	return suspected
+}
+
+func (m *Dimex) sendToLink(address string, content string) {
+	kind := common.MessageKind(content)
+	if m.log.Logger.IsLevelEnabled(logrus.DebugLevel) { // evita montar os campos a cada envio
+		m.log.WithFields(logrus.Fields{"kind": kind, "to": address}).Debug("---->>>> " + content)
+	}
+	m.sentMu.Lock()
+	m.sent[kind]++
+	m.sentMu.Unlock()
+	m.messagesSent.Inc(kind)
 	m.Pp2plink.Req <- pp2plink.ReqMsg{
-		To:      address,
-		Message: content}
+		To:         address,
+		Message:    content,
+		Unreliable: kind == HEARTBEAT} // periodicos: um heartbeat perdido e substituido pelo seguinte
+}
+
+// authentic tells whether the given message was sent by the process whose PID it claims: with
+// mutual TLS, the identity in the certificate of its sender must be the address of that process.
+// The messages of the membership are checked against the identities of the processes that may
+// send them:
+//   - a request to join the group, from the process joining it (its address must be its
+//     identity) or forwarded to the coordinator by a member;
+//   - a request to leave the group, from the process leaving it or forwarded to the coordinator by
+//     a member;
+//   - a view, only from the coordinator of the membership.
+func (m *Dimex) authentic(msg pp2plink.IndMsg) bool {
+	if msg.Peer == "" { // sem TLS
+		return true
+	}
+	parts := strings.Split(msg.Message, ";")
+	if len(parts) < 2 {
+		return false
+	}
+	forwarded := m.coordinator() == m.id && memberPID(m.addresses, m.members, msg.Peer) >= 0
+	switch common.MessageKind(msg.Message) {
+	case JOIN:
+		return len(parts) > 2 && parts[2] == msg.Peer || forwarded
+	case LEAVE:
+		pid, _ := strconv.Atoi(parts[1])
+		return sentBy(msg, m.addresses, pid) || forwarded
+	case VIEW:
+		pid, _ := strconv.Atoi(parts[1])
+		return pid == m.coordinator() && sentBy(msg, m.addresses, pid)
+	}
+	pid, err := strconv.Atoi(parts[1])
+	return err == nil && sentBy(msg, m.addresses, pid)
 }
 
-func after(oneTs, oneId, otherTs, otherId int) bool {
-	return oneTs > otherTs || (oneTs == otherTs && oneId > otherId)
+// sentBy tells whether the given message was sent by the process with the given PID, i.e., whether
+// the identity in the certificate of its sender is the address of that process.
+func sentBy(msg pp2plink.IndMsg, addresses []string, pid int) bool {
+	return pid >= 0 && pid < len(addresses) && addresses[pid] == msg.Peer
+}
+
+// MessagesSent returns the number of messages sent by this process so far, by kind.
+func (m *Dimex) MessagesSent() map[string]int64 {
+	m.sentMu.Lock()
+	defer m.sentMu.Unlock()
+
+	sent := make(map[string]int64, len(m.sent))
+	for kind, n := range m.sent {
+		sent[kind] = n
+	}
+	return sent
+}
+
+// mode returns the mode of a request to the CS in a reqEntry message.
+func mode(shared bool) string {
+	if shared {
+		return common.SHARED
+	}
+	return common.EXCLUSIVE
 }
 
 func max(one, oth int) int {
@@ -349,12 +1082,28 @@ func max(one, oth int) int {
 }
 
 func (m *Dimex) outDbg(s string) {
-	if m.dbg {
-		fmt.Println(". . . . . . . . . . . . [ DIMEX : " + s + " ]")
+	m.log.Debug(s)
+}
+
+// initiateSnapshot initiates a new snapshot by sending a snapshot message to this process itself,
+// and returns its ID.
+func (m *Dimex) initiateSnapshot() int {
+	snapId := 0
+	if m.lastSnapshot != nil {
+		snapId = m.lastSnapshot.ID + 1
 	}
+	m.snapLog.WithField("snapId", snapId).Info("=========== initiating a snapshot ===========")
+	// send a snapshot message to myself
+	m.sendToLink(
+		m.addresses[m.id],
+		fmt.Sprintf("%s;%d;%d", SNAP, m.id, snapId),
+	)
+	return snapId
 }
 
 func (m *Dimex) takeSnapshot(snapId int) {
+	now := time.Now()
+	m.expireOverdueLease(now)
 	waiting := make([]bool, len(m.waiting))
 	copy(waiting, m.waiting)
 	m.lastSnapshot = snapshots.NewSnapshot(snapshots.ProcessState{
@@ -365,18 +1114,33 @@ func (m *Dimex) takeSnapshot(snapId int) {
 		LocalClock: m.lcl,
 		ReqTs:      m.reqTs,
 		NbrResps:   m.nbrResps,
+		Suspected:  m.suspectedCopy(),
+		Members:    m.membersCopy(),
+		Shared:     m.shared && m.st != common.NoMX,
+		Priority:   m.reqPriority,
+		Lease:      m.leaseLeft(now),
+		Expired:    m.leaseExpired,
 	})
+	for i, suspected := range m.suspected {
+		if suspected || !m.isMember(i) { // processos suspeitos e fora do grupo nao enviam o SNAP
+			m.lastSnapshot.CloseChan(i)
+		}
+	}
+	m.snapshotSince = time.Now()
+	if m.tracer != nil {
+		m.tracer.Local(fmt.Sprintf("snapshot %d taken", snapId))
+	}
+	m.notify(func(o Observer) { o.SnapshotStarted(m.id, snapId) })
 
 	for i, addr := range m.addresses {
-		if i == m.id {
+		if i == m.id || !m.isMember(i) {
 			continue
 		}
 		m.sendToLink(
 			addr,
 			fmt.Sprintf("%s;%d;%d", SNAP, m.id, snapId),
-			fmt.Sprintf("PID %d", m.id),
 		)
-		logrus.Debugf("P%d: sent SNAP to %s (PID %d)\n", m.id, addr, i)
+		m.snapLog.WithFields(logrus.Fields{"snapId": snapId, "to": i}).Debug("sent SNAP")
 	}
 }
 
@@ -385,7 +1149,8 @@ func (m *Dimex) messagesMiddleware(msg pp2plink.IndMsg) pp2plink.IndMsg {
 	senderId, _ := strconv.Atoi(parts[1])
 
 	if strings.Contains(msg.Message, SNAP) {
-		logrus.Debugf("\tP%d: received SNAP from %s (PID %d)\n", m.id, msg.From, senderId)
+		snapId, _ := strconv.Atoi(parts[2])
+		m.snapLog.WithFields(logrus.Fields{"snapId": snapId, "from": senderId}).Debug("received SNAP")
 		return msg
 	}
 
@@ -393,7 +1158,9 @@ func (m *Dimex) messagesMiddleware(msg pp2plink.IndMsg) pp2plink.IndMsg {
 		return msg
 	}
 
-	m.lastSnapshot.CommunicationChans[senderId].AddMessage(msg)
+	if commChan, ok := m.lastSnapshot.CommunicationChans[senderId]; ok {
+		commChan.AddMessage(msg)
+	}
 
 	return msg
 }
@@ -0,0 +1,244 @@
+package dimex
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"pucrs/sd/failuredetector"
+	"pucrs/sd/fencing"
+	"pucrs/sd/pp2plink"
+	"pucrs/sd/snapshots"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testTimeout bounds the wait for an indication of the module that is expected to arrive
+const testTimeout = 5 * time.Second
+
+// quietLogger returns a logger that discards its entries.
+func quietLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logrus.NewEntry(logger)
+}
+
+// freeAddresses returns n local addresses that are free to listen on.
+func freeAddresses(t *testing.T, n int) []string {
+	t.Helper()
+	addresses := make([]string, n)
+	for i := range addresses {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("freeAddresses: %v", err)
+		}
+		addresses[i] = listener.Addr().String()
+		listener.Close()
+	}
+	return addresses
+}
+
+// testOpts returns the given options after the ones of the processes of the tests: snapshots taken
+// only when triggered, and logs discarded.
+func testOpts(opts ...Opt) []Opt {
+	return append([]Opt{
+		WithSnapshotIntervalOpt(3600),
+		WithLoggerOpt(quietLogger()),
+		WithSnapshotLoggerOpt(quietLogger()),
+		WithLinkLoggerOpt(quietLogger()),
+	}, opts...)
+}
+
+// newTestGroup starts a group of n processes on local addresses, in the same way as the bench, with
+// the given options (see testOpts).
+func newTestGroup(t *testing.T, n int, opts ...Opt) []*Dimex {
+	t.Helper()
+	addresses := freeAddresses(t, n)
+	opts = testOpts(opts...)
+
+	group := make([]*Dimex, n)
+	for i := range addresses {
+		group[i] = NewDimex(addresses, i, opts...)
+	}
+	for _, address := range addresses {
+		awaitListening(t, address)
+	}
+	return group
+}
+
+// awaitListening waits until the link at the given address accepts connections: the messages sent
+// to it before are lost, unless the links are reliable.
+func awaitListening(t *testing.T, address string) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("awaitListening(%s): %v", address, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// awaitInd waits for the next indication of the given process.
+func awaitInd(t *testing.T, m *Dimex) dmxResp {
+	t.Helper()
+	select {
+	case resp := <-m.Ind:
+		return resp
+	case <-time.After(testTimeout):
+		t.Fatalf("P%d: no indication within %v", m.id, testTimeout)
+		return dmxResp{}
+	}
+}
+
+// assertNoInd checks that the given process gets no indication for the given time.
+func assertNoInd(t *testing.T, m *Dimex, d time.Duration) {
+	t.Helper()
+	select {
+	case resp := <-m.Ind:
+		t.Fatalf("P%d: unexpected indication %+v", m.id, resp)
+	case <-time.After(d):
+	}
+}
+
+// suspect makes the failure detector of the given process suspect the process with the given PID.
+func suspect(m *Dimex, pid int) {
+	m.control(func() { m.handleSuspicion(failuredetector.Event{PID: pid, Suspected: true}) })
+}
+
+func TestFalseSuspicionKeepsMutualExclusion(t *testing.T) {
+	cfg := failuredetector.DefaultConfig(failuredetector.EventuallyPerfect)
+	cfg.Timeout = time.Minute // no suspicion but the injected one
+	group := newTestGroup(t, 2, WithFailureDetectorOpt(cfg))
+
+	group[0].Req <- ENTER
+	awaitInd(t, group[0])
+
+	// P1 falsely suspects P0, which is in the CS: it must still wait for its permission
+	suspect(group[1], 0)
+	group[1].Req <- ENTER
+	assertNoInd(t, group[1], 500*time.Millisecond)
+
+	group[0].Req <- EXIT
+	awaitInd(t, group[1])
+}
+
+func TestPerfectSuspicionExcusesProcess(t *testing.T) {
+	cfg := failuredetector.DefaultConfig(failuredetector.Perfect)
+	cfg.Timeout = time.Minute
+	group := newTestGroup(t, 2, WithFailureDetectorOpt(cfg))
+
+	group[0].Req <- ENTER
+	awaitInd(t, group[0])
+
+	// with the perfect failure detector, P0 is trusted to have crashed
+	suspect(group[1], 0)
+	group[1].Req <- ENTER
+	awaitInd(t, group[1])
+}
+
+// eventually waits until the given condition holds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: not within %v", what, testTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// inTempDir runs the rest of the test in a temporary directory, where the snapshots are dumped.
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("inTempDir: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("inTempDir: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestFailureSimulationTripsInvariants(t *testing.T) {
+	inTempDir(t)
+	collector := snapshots.NewCollector(2, snapshots.WithLoggerOpt(quietLogger()))
+	group := newTestGroup(t, 2, WithCollectorOpt(collector))
+	group[0].SetFail(true)
+
+	group[0].Req <- ENTER
+	awaitInd(t, group[0])
+
+	// P0 does not delay its permission while in the CS: P1 enters too
+	group[1].Req <- ENTER
+	awaitInd(t, group[1])
+
+	group[0].TriggerSnapshot()
+	eventually(t, "invariant violation", func() bool { return collector.Violation() != nil })
+	if err := collector.Violation(); !strings.Contains(err.Error(), "checkMutualExclusion") {
+		t.Errorf("violation = %v, want a violation of checkMutualExclusion", err)
+	}
+}
+
+func TestDuplicateRespOkCountedOnce(t *testing.T) {
+	group := newTestGroup(t, 3)
+
+	group[2].Req <- ENTER
+	awaitInd(t, group[2])
+
+	// P0 gets the permission of P1, while P2 delays its own
+	group[0].Req <- ENTER
+	eventually(t, "permission of P1", func() bool { return group[0].Inspect().NbrResps == 1 })
+
+	// a repeated permission of P1 must not stand for the one of P2
+	group[0].control(func() {
+		group[0].handleUponDeliverRespOk(pp2plink.IndMsg{From: group[0].addresses[1], Message: RESP_OK + ";1;0"})
+	})
+	assertNoInd(t, group[0], 300*time.Millisecond)
+
+	group[2].Req <- EXIT
+	awaitInd(t, group[0])
+}
+
+func TestFencingAcrossExcusedHolder(t *testing.T) {
+	cfg := failuredetector.DefaultConfig(failuredetector.Perfect)
+	cfg.Timeout = time.Minute
+	group := newTestGroup(t, 2, WithFailureDetectorOpt(cfg))
+	validator := fencing.NewValidator()
+	access := func() error { return nil }
+
+	// P0 enters the CS several times, on its own, then hangs in it
+	var held fencing.Token
+	for i := 0; i < 3; i++ {
+		group[0].Req <- ENTER
+		held = awaitInd(t, group[0]).Token
+		if err := validator.Guard(held, access); err != nil {
+			t.Fatalf("P0 access with token %v: %v", held, err)
+		}
+		if i < 2 {
+			group[0].Req <- EXIT
+		}
+	}
+
+	// P1 suspects P0 and enters without its permission, never having seen its tokens
+	suspect(group[1], 0)
+	group[1].Req <- ENTER
+	token := awaitInd(t, group[1]).Token
+	if err := validator.Guard(token, access); err != nil {
+		t.Errorf("P1 access with token %v after P0 with token %v: %v", token, held, err)
+	}
+	if err := validator.Guard(held, access); !errors.Is(err, fencing.ErrStaleToken) {
+		t.Errorf("P0 access with token %v after P1 with token %v = %v, want %v", held, token, err, fencing.ErrStaleToken)
+	}
+}
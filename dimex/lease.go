@@ -0,0 +1,94 @@
+package dimex
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ------------------------------------------------------------------------------------
+// ------- secoes criticas com lease (expiracao automatica)
+// ------- o modulo libera a SC quando o lease expira, como se a aplicacao tivesse saido
+// ------------------------------------------------------------------------------------
+
+// WithLeaseOpt is an option to hold the CS under a lease of the given duration on every entry. A
+// process that holds the CS past its lease (e.g., because its application hangs) is treated as if
+// it had exited it: its module answers the deferred requests, so the other processes can enter the
+// CS, and the application is notified with an indication whose Expired flag is set. The EXIT
+// request that the application sends afterwards is ignored.
+func WithLeaseOpt(lease time.Duration) Opt {
+	return func(m *Dimex) {
+		m.lease = lease
+	}
+}
+
+// startLease starts the lease of the CS just entered, if any.
+func (m *Dimex) startLease() {
+	if m.lease <= 0 {
+		return
+	}
+	m.leaseDeadline = time.Now().Add(m.lease)
+	m.leaseTimer = time.NewTimer(m.lease)
+}
+
+// stopLease stops the lease of the CS, if any.
+func (m *Dimex) stopLease() {
+	if m.leaseTimer != nil {
+		m.leaseTimer.Stop()
+		m.leaseTimer = nil
+	}
+	m.leaseDeadline = time.Time{}
+}
+
+// leaseC returns the channel of the timer of the lease, or nil (blocks forever) without a lease.
+func (m *Dimex) leaseC() <-chan time.Time {
+	if m.leaseTimer == nil {
+		return nil
+	}
+	return m.leaseTimer.C
+}
+
+// leaseLeft returns how long the CS can still be held under its lease at the given time, or 0
+// without a lease.
+func (m *Dimex) leaseLeft(now time.Time) time.Duration {
+	if m.leaseDeadline.IsZero() {
+		return 0
+	}
+	return m.leaseDeadline.Sub(now)
+}
+
+/*
+upon event [ timer, Timeout | lease ]  do
+
+	se estado == estouNaSC
+	então  para todo [p, r, ts ] em waiting
+			trigger [ pl, Send | p , [ respOk, r ]  ]
+		estado := naoQueroSC
+		waiting := {}
+		trigger [ dmx, Deliver | expired ]
+*/
+func (m *Dimex) handleLeaseExpired() {
+	if m.leaseTimer == nil {
+		return // a aplicacao saiu da SC antes
+	}
+	m.log.WithFields(logrus.Fields{"lease": m.lease, "reqTs": m.reqTs}).Warn("CS held past its lease: releasing it")
+	m.handleUponReqExit()
+	m.leaseExpired = true
+	m.leasesExpired.Inc()
+
+	select {
+	case m.Ind <- dmxResp{Expired: true, Token: m.token}:
+	default:
+		// a aplicacao nao consumiu a indicacao de entrada
+		m.log.Warn("application not notified of the expired lease: indication channel full")
+	}
+}
+
+// expireOverdueLease releases the CS if its lease is over at the given time, even if the timer has
+// not been handled yet by the event loop, e.g., so a snapshot never records a CS held past its
+// lease.
+func (m *Dimex) expireOverdueLease(now time.Time) {
+	if m.leaseTimer != nil && !now.Before(m.leaseDeadline) {
+		m.handleLeaseExpired()
+	}
+}
@@ -0,0 +1,35 @@
+package dimex
+
+import (
+	"testing"
+	"time"
+
+	"pucrs/sd/common"
+)
+
+func TestLeaseExpiryReleasesCS(t *testing.T) {
+	group := newTestGroup(t, 2, WithLeaseOpt(200*time.Millisecond))
+
+	group[0].Req <- ENTER
+	entry := awaitInd(t, group[0])
+	group[1].Req <- ENTER
+
+	// P0 hangs in the CS: its lease expires, and P1 enters
+	if resp := awaitInd(t, group[0]); !resp.Expired || resp.Token != entry.Token {
+		t.Errorf("P0 indication = %+v, want the expiry of the lease of token %v", resp, entry.Token)
+	}
+	if resp := awaitInd(t, group[1]); resp.Expired || resp.Err != nil {
+		t.Errorf("P1 indication = %+v, want an entry", resp)
+	}
+
+	// the late exit of P0 is ignored
+	group[0].Req <- EXIT
+	if status := group[1].Inspect(); status.State != common.InMX.String() {
+		t.Errorf("P1 state after the late exit of P0 = %s, want %s", status.State, common.InMX)
+	}
+	group[1].Req <- EXIT
+	group[0].Req <- ENTER
+	if resp := awaitInd(t, group[0]); resp.Expired || resp.Err != nil {
+		t.Errorf("P0 indication = %+v, want an entry", resp)
+	}
+}
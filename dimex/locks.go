@@ -0,0 +1,146 @@
+package dimex
+
+import (
+	"errors"
+	"fmt"
+	"pucrs/sd/common"
+	"pucrs/sd/fencing"
+	"sort"
+)
+
+var (
+	// ErrAlreadyRequested is the error of a request to the CS while the previous one is still
+	// pending: the application must wait for the entry before requesting the CS again. It is
+	// returned right away by the LockSet, without an indication on the Ind channel, which belongs to
+	// the pending request; an ENTER sent on the Req channel meanwhile is logged and ignored.
+	ErrAlreadyRequested = errors.New("request to the CS already pending")
+	// ErrUpgrade is the error of an exclusive request to the CS while the process holds it in
+	// shared mode: a reader cannot become a writer without leaving the CS first, since two readers
+	// doing so would wait for each other forever.
+	ErrUpgrade = errors.New("shared hold of the CS cannot be upgraded to exclusive")
+	// ErrLockOrder is the error of an acquisition of named locks that does not follow the order of
+	// their names (see LockSet).
+	ErrLockOrder = errors.New("named locks acquired out of order")
+)
+
+// ------------------------------------------------------------------------------------
+// ------- secoes criticas reentrantes e travas nomeadas
+// ------- cada trava e um modulo DIMEX; as travas sao adquiridas em ordem crescente de nome
+// ------------------------------------------------------------------------------------
+
+// enter requests the CS in exclusive mode and waits for the entry, skipping the indications of the
+// expired leases of former entries. It returns the fencing token of the entry, or the error of a
+// rejected request. A request while another one is pending (e.g., sent by another goroutine on the
+// Req channel) is rejected right away, without waiting on the Ind channel for the entry of the
+// pending request.
+func (m *Dimex) enter() (fencing.Token, error) {
+	var pending bool
+	m.control(func() { pending = m.st == common.WantMX })
+	if pending {
+		return 0, fmt.Errorf("dimex.enter: %w", ErrAlreadyRequested)
+	}
+
+	m.Req <- ENTER
+	resp := <-m.Ind
+	for resp.Expired { // o lease de uma entrada anterior expirou logo antes do EXIT
+		resp = <-m.Ind
+	}
+	return resp.Token, resp.Err
+}
+
+// LockSet is a set of named locks of a process, each one a DIMEX module of its own (a group with
+// its own addresses). Acquiring several locks one at a time could deadlock, with two processes
+// each holding a lock the other one waits for, so a LockSet acquires them in ascending order of
+// their names: since all processes follow the same order, no process waits for a lock held by a
+// process that waits for a lock it holds.
+//
+// The locks are re-entrant: a lock already held is entered again (nested), and must be exited as
+// many times as it was entered. A LockSet must be used by a single goroutine of the application,
+// as the Req and Ind channels of its modules.
+type LockSet struct {
+	locks map[string]*Dimex
+	held  map[string]int // entradas aninhadas de cada trava ainda sem EXIT
+}
+
+// NewLockSet creates a new LockSet with the given locks, by name, none of them held.
+func NewLockSet(locks map[string]*Dimex) *LockSet {
+	return &LockSet{locks: locks, held: make(map[string]int)}
+}
+
+// Enter acquires the locks with the given names in exclusive mode, in ascending order of names,
+// and returns the fencing token of the entry in each one, by name. The locks not held yet must all
+// be ordered after the ones already held, otherwise ErrLockOrder is returned: the order cannot be
+// followed anymore. If a lock cannot be acquired, the locks acquired by the call are released, and
+// the error is returned.
+func (s *LockSet) Enter(names ...string) (map[string]fencing.Token, error) {
+	names = sortedNames(names)
+	highest := ""
+	for name := range s.held {
+		if name > highest {
+			highest = name
+		}
+	}
+	for _, name := range names {
+		if _, ok := s.locks[name]; !ok {
+			return nil, fmt.Errorf("dimex.LockSet.Enter: unknown lock '%s'", name)
+		}
+		if s.held[name] == 0 && name < highest {
+			return nil, fmt.Errorf("dimex.LockSet.Enter: lock '%s' after lock '%s': %w", name, highest, ErrLockOrder)
+		}
+	}
+
+	tokens := make(map[string]fencing.Token, len(names))
+	for i, name := range names {
+		token, err := s.locks[name].enter()
+		if err != nil {
+			s.Exit(names[:i]...) // adquiridas nesta chamada: nao falha
+			return nil, fmt.Errorf("dimex.LockSet.Enter: failed to acquire lock '%s': %w", name, err)
+		}
+		s.held[name]++
+		tokens[name] = token
+	}
+	return tokens, nil
+}
+
+// Exit releases the locks with the given names, in descending order of names. It returns an error
+// if any of them is not held, without releasing any lock.
+func (s *LockSet) Exit(names ...string) error {
+	names = sortedNames(names)
+	for _, name := range names {
+		if s.held[name] == 0 {
+			return fmt.Errorf("dimex.LockSet.Exit: lock '%s' not held", name)
+		}
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		s.locks[names[i]].Req <- EXIT
+		s.held[names[i]]--
+		if s.held[names[i]] == 0 {
+			delete(s.held, names[i])
+		}
+	}
+	return nil
+}
+
+// Held returns the nested entries in each lock held, by name.
+func (s *LockSet) Held() map[string]int {
+	held := make(map[string]int, len(s.held))
+	for name, holds := range s.held {
+		held[name] = holds
+	}
+	return held
+}
+
+// sortedNames returns the given names in ascending order, without repetitions.
+func sortedNames(names []string) []string {
+	sorted := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,76 @@
+package dimex
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"pucrs/sd/common"
+)
+
+func TestNestedEntry(t *testing.T) {
+	group := newTestGroup(t, 2)
+	locks := NewLockSet(map[string]*Dimex{"a": group[0]})
+
+	outer, err := locks.Enter("a")
+	if err != nil {
+		t.Fatalf("Enter: %v", err)
+	}
+	inner, err := locks.Enter("a")
+	if err != nil {
+		t.Fatalf("nested Enter: %v", err)
+	}
+	if inner["a"] != outer["a"] {
+		t.Errorf("token of the nested entry = %v, want %v", inner["a"], outer["a"])
+	}
+
+	// the CS is only released by the last exit
+	if err := locks.Exit("a"); err != nil {
+		t.Fatalf("Exit: %v", err)
+	}
+	if status := group[0].Inspect(); status.State != common.InMX.String() || status.Holds != 1 {
+		t.Errorf("after the first exit: state %s with %d holds, want %s with 1", status.State, status.Holds, common.InMX)
+	}
+	if err := locks.Exit("a"); err != nil {
+		t.Fatalf("Exit: %v", err)
+	}
+	eventually(t, "release of the CS", func() bool { return group[0].Inspect().State == common.NoMX.String() })
+}
+
+func TestRepeatedRequestLeavesIndToPendingOne(t *testing.T) {
+	group := newTestGroup(t, 2)
+
+	group[1].Req <- ENTER
+	awaitInd(t, group[1])
+
+	// a goroutine of the application waits for the entry of P0
+	entered := make(chan dmxResp, 1)
+	group[0].Req <- ENTER
+	go func() { entered <- <-group[0].Ind }()
+	eventually(t, "request of P0", func() bool { return group[0].Inspect().State == common.WantMX.String() })
+
+	// another request is rejected right away, or ignored, without an indication
+	locks := NewLockSet(map[string]*Dimex{"a": group[0]})
+	if _, err := locks.Enter("a"); !errors.Is(err, ErrAlreadyRequested) {
+		t.Errorf("Enter while requesting = %v, want %v", err, ErrAlreadyRequested)
+	}
+	group[0].Req <- ENTER
+	select {
+	case resp := <-entered:
+		t.Fatalf("indication before the entry: %+v", resp)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// the entry goes to the goroutine waiting for it, and the event loop is not blocked
+	group[1].Req <- EXIT
+	select {
+	case resp := <-entered:
+		if resp.Err != nil || resp.Expired {
+			t.Errorf("indication = %+v, want the entry", resp)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("no entry within %v", testTimeout)
+	}
+	group[0].Req <- EXIT
+	eventually(t, "release of the CS", func() bool { return group[0].Inspect().State == common.NoMX.String() })
+}
@@ -0,0 +1,421 @@
+package dimex
+
+import (
+	"fmt"
+	"pucrs/sd/common"
+	"pucrs/sd/pp2plink"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// membershipTimeout is how long a process waits to join or leave the group
+	membershipTimeout = 5 * time.Second
+	// leavePollInterval is the time between checks of whether a process leaving the group is
+	// neither requesting nor in the CS
+	leavePollInterval = 10 * time.Millisecond
+	// maxBacklog is how many messages from processes not in the current view of a process (that
+	// joined the group in a view it has not installed yet) it keeps until it installs that view
+	maxBacklog = 1024
+)
+
+// ------------------------------------------------------------------------------------
+// ------- composicao dinamica do grupo (membership)
+// ------- o coordenador (membro de menor PID nao excluido pelo detector perfeito) ordena as
+// ------- mudancas em visoes
+// ------- UPON join
+// ------- UPON leave
+// ------- UPON view
+// ------------------------------------------------------------------------------------
+
+// JoinDimex creates a new instance of a DIMEX module for a process that joins a running group,
+// listening on the given address, through the member of the group at the seed address. The process
+// gets the next PID (PIDs are never reused) once the coordinator of the membership installs a view
+// of the group with it. It returns an error if the process does not join the group in time.
+//
+// Processes that join the group are not recovered from a write-ahead log (see WithWALOpt).
+//
+// The views are agreed on because a single coordinator installs them, one after the other, and
+// sends them over FIFO links. This requires that the processes agree on the coordinator, i.e., that
+// no failure detector or the perfect one is used (see WithFailureDetectorOpt): with the eventually
+// perfect one, a crashed coordinator blocks the changes of the group. Even with the perfect one, a
+// coordinator that crashes while sending a view may leave the members with different views; the
+// views that conflict with the installed one are then rejected (and logged) rather than installed.
+func JoinDimex(seed string, address string, opts ...Opt) (*Dimex, error) {
+	dmx := newDimex(opts...)
+	dmx.linkLog = dmx.linkLog.WithField("address", address)
+	dmx.Pp2plink = dmx.newLink(address)
+
+	dmx.sendToLink(seed, fmt.Sprintf("%s;%d;%s", JOIN, -1, address))
+
+	timeout := time.After(membershipTimeout)
+	for {
+		select {
+		case msg := <-dmx.Pp2plink.Ind:
+			if common.MessageKind(msg.Message) != VIEW {
+				// de membros que ja instalaram a visao com este processo
+				dmx.backlog = append(dmx.backlog, msg)
+				continue
+			}
+			view, addresses, members := parseView(msg.Message)
+			sender, _ := strconv.Atoi(strings.Split(msg.Message, ";")[1])
+			if msg.Peer != "" && !(sentBy(msg, addresses, sender) && members[sender]) {
+				// com TLS, a visao deve vir de um membro dela (o coordenador, ainda desconhecido)
+				dmx.log.WithField("peer", msg.Peer).Warn("view rejected: not sent by a member of the group")
+				continue
+			}
+			id := memberPID(addresses, members, address)
+			if id < 0 {
+				continue // visao anterior a entrada deste processo
+			}
+			dmx.init(addresses, id, view, members)
+			dmx.Start()
+			dmx.log.WithFields(logrus.Fields{"view": view, "seed": seed}).Info("joined the group")
+			return dmx, nil
+		case <-timeout:
+			return nil, fmt.Errorf("dimex.JoinDimex: not joined the group through '%s' within %v", seed, membershipTimeout)
+		}
+	}
+}
+
+// Leave makes the process leave the group gracefully. It waits until the process is neither
+// requesting nor in the CS, asks the coordinator of the membership to remove it from the group, and
+// waits for the view without it. The application must not request the CS after calling Leave
+// (e.g., by closing its workload.Gate): afterwards, the process takes part neither in the mutual
+// exclusion nor in the snapshots. It returns an error if the process does not leave the group in
+// time.
+func (m *Dimex) Leave() error {
+	deadline := time.Now().Add(membershipTimeout)
+	for {
+		requested := false
+		m.control(func() {
+			if m.st == common.NoMX && !m.recovering {
+				m.requestLeave()
+				requested = true
+			}
+		})
+		if requested {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("dimex.Leave: process still requesting or in the CS after %v", membershipTimeout)
+		}
+		time.Sleep(leavePollInterval)
+	}
+
+	select {
+	case <-m.left:
+		return nil
+	case <-time.After(time.Until(deadline)):
+		return fmt.Errorf("dimex.Leave: not left the group within %v", membershipTimeout)
+	}
+}
+
+// requestLeave asks the coordinator of the membership to remove this process from the group.
+func (m *Dimex) requestLeave() {
+	if !m.isMember(m.id) {
+		return // ja saiu do grupo
+	}
+	coordinator := m.coordinator()
+	if coordinator == m.id {
+		m.applyLeave(m.id)
+		return
+	}
+	m.sendToLink(m.addresses[coordinator], fmt.Sprintf("%s;%d", LEAVE, m.id))
+}
+
+/*
+upon event [ pl, Deliver | p, [ join, endereco ] ]  do
+
+	se nao sou o coordenador
+	então  trigger [ pl, Send | coordenador, [ join, endereco ] ]
+	senão  membros := membros + [ novo PID, endereco ]
+		visao++
+		para todo processo q em membros
+			trigger [ pl, Send | q, [ view, visao, membros ] ]
+*/
+func (m *Dimex) handleJoin(msg pp2plink.IndMsg) {
+	address := strings.Split(msg.Message, ";")[2]
+
+	coordinator := m.coordinator()
+	if coordinator != m.id {
+		m.sendToLink(m.addresses[coordinator], msg.Message)
+		return
+	}
+
+	if pid := memberPID(m.addresses, m.members, address); pid >= 0 {
+		m.sendView(pid) // pedido repetido: o processo ja esta no grupo
+		return
+	}
+
+	addresses := append(m.addresses[:len(m.addresses):len(m.addresses)], address)
+	members := append(m.membersCopyAll(), true)
+	m.log.WithFields(logrus.Fields{"process": len(addresses) - 1, "address": address}).Info("process joining the group")
+	m.installView(m.view+1, addresses, members)
+	m.broadcastView(-1)
+}
+
+/*
+upon event [ pl, Deliver | p, [ leave, r ] ]  do
+
+	se nao sou o coordenador
+	então  trigger [ pl, Send | coordenador, [ leave, r ] ]
+	senão  membros := membros - [ r ]
+		visao++
+		para todo processo q em membros + [ r ]
+			trigger [ pl, Send | q, [ view, visao, membros ] ]
+*/
+func (m *Dimex) handleLeave(msg pp2plink.IndMsg) {
+	otherId, _ := strconv.Atoi(strings.Split(msg.Message, ";")[1])
+
+	coordinator := m.coordinator()
+	if coordinator != m.id {
+		m.sendToLink(m.addresses[coordinator], msg.Message)
+		return
+	}
+	m.applyLeave(otherId)
+}
+
+// applyLeave removes the process with the given PID from the group, as the coordinator of the
+// membership.
+func (m *Dimex) applyLeave(pid int) {
+	if !m.isMember(pid) {
+		m.sendView(pid) // pedido repetido: o processo ja saiu do grupo
+		return
+	}
+
+	members := m.membersCopyAll()
+	members[pid] = false
+	m.log.WithField("process", pid).Info("process leaving the group")
+	m.installView(m.view+1, m.addresses, members)
+	m.broadcastView(pid)
+}
+
+/*
+upon event [ pl, Deliver | p, [ view, v, membros ] ]  do
+
+	se v > visao e membros estende a visao instalada
+	então  instala a visao v
+		se estado == queroSC
+		então  para todo novo membro q
+			trigger [ pl, Send | q, [ reqEntry, r, myTs ] ]
+*/
+func (m *Dimex) handleView(msg pp2plink.IndMsg) {
+	view, addresses, members := parseView(msg.Message)
+	m.installView(view, addresses, members)
+}
+
+// installView installs the given view of the group, if it is newer than the current one and does
+// not conflict with it (see conflicts). The state of the process is resized for the processes that
+// joined the group, and a pending request is sent to them. The processes that left the group are no
+// longer waited for, neither for their permissions nor for their snapshot messages.
+func (m *Dimex) installView(view int, addresses []string, members []bool) {
+	if view < m.view {
+		return // visao antiga
+	}
+	if m.conflicts(addresses, members, view == m.view) {
+		m.log.WithFields(logrus.Fields{"view": view, "members": members}).Warn("view rejected: conflicts with the installed one")
+		return
+	}
+	if view == m.view {
+		return // visao repetida
+	}
+	defer m.replayBacklog() // recebidas dos processos que entraram no grupo nesta visao
+	wasMember := m.isMember(m.id)
+	previous := m.members
+
+	for len(m.waiting) < len(addresses) {
+		m.waiting = append(m.waiting, false)
+		m.suspected = append(m.suspected, false)
+		m.requested = append(m.requested, false)
+		m.granted = append(m.granted, false)
+		if m.recovering {
+			m.recoverAcks = append(m.recoverAcks, false)
+			m.recoverReqs = append(m.recoverReqs, false)
+		}
+	}
+	m.addrMu.Lock()
+	m.addresses = addresses
+	m.addrMu.Unlock()
+	m.view = view
+	m.members = members
+	m.log.WithFields(logrus.Fields{"view": view, "members": members}).Info("installed a new view of the group")
+
+	if m.detector != nil {
+		m.detector.SetMembers(members)
+	}
+
+	// os processos que entraram no snapshot corrente ou sairam do grupo nao enviam o SNAP
+	if m.lastSnapshot != nil && m.lastCompletedSnap != m.lastSnapshot {
+		for i := range addresses {
+			if !m.isMember(i) || i >= len(previous) || !previous[i] {
+				m.lastSnapshot.CloseChan(i)
+			}
+		}
+		m.completeSnapshot()
+	}
+
+	if !m.isMember(m.id) {
+		if wasMember {
+			if m.detector != nil {
+				m.detector.Stop()
+			}
+			close(m.left)
+			m.log.WithField("view", view).Info("left the group")
+		}
+		return
+	}
+
+	if m.st == common.WantMX {
+		for i := range addresses {
+			if i != m.id && m.isMember(i) && !m.excluded(i) && !m.requested[i] {
+				m.sendReqEntry(i)
+			}
+		}
+	}
+	m.tryFinishRecovery()
+	m.tryEnterCS()
+}
+
+// broadcastView sends the current view of the group to all other members, and to the process with
+// the given PID (e.g., the one that left the group), if any.
+func (m *Dimex) broadcastView(also int) {
+	for i := range m.addresses {
+		if i != m.id && (m.isMember(i) || i == also) {
+			m.sendView(i)
+		}
+	}
+}
+
+func (m *Dimex) sendView(to int) {
+	bits := make([]string, len(m.members))
+	for i, member := range m.members {
+		bits[i] = "0"
+		if member {
+			bits[i] = "1"
+		}
+	}
+	m.sendToLink(
+		m.addresses[to],
+		fmt.Sprintf("%s;%d;%d;%s;%s", VIEW, m.id, m.view, strings.Join(m.addresses, ","), strings.Join(bits, "")),
+	)
+}
+
+// parseView parses a view message: view;<pid>;<view>;<address>,<address>,...;<member bits>
+func parseView(message string) (view int, addresses []string, members []bool) {
+	parts := strings.Split(message, ";")
+	view, _ = strconv.Atoi(parts[2])
+	addresses = strings.Split(parts[3], ",")
+	members = make([]bool, len(addresses))
+	for i := range members {
+		members[i] = i < len(parts[4]) && parts[4][i] == '1'
+	}
+	return view, addresses, members
+}
+
+// memberPID returns the PID of the member of the group with the given address, or -1 if there is
+// none. An address may have been used by a process that left the group.
+func memberPID(addresses []string, members []bool, address string) int {
+	for i := len(addresses) - 1; i >= 0; i-- {
+		if addresses[i] == address && i < len(members) && members[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// conflicts tells whether the given view conflicts with the installed one, i.e., whether the views
+// were not both installed by the coordinator of the membership, one after the other: a newer view
+// keeps the addresses of the installed one (PIDs are never reused) and does not bring back a process
+// that left the group, and a view with the same number (same) is the same view.
+func (m *Dimex) conflicts(addresses []string, members []bool, same bool) bool {
+	if len(addresses) < len(m.addresses) || (same && len(addresses) != len(m.addresses)) {
+		return true
+	}
+	for i, address := range m.addresses {
+		if addresses[i] != address || (members[i] && !m.isMember(i)) || (same && members[i] != m.isMember(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// coordinator returns the PID of the coordinator of the membership: the member of the group with
+// the lowest PID that is not excluded by the perfect failure detector. The suspicions of the
+// eventually perfect one may be false, and two coordinators would install conflicting views.
+func (m *Dimex) coordinator() int {
+	for i := range m.addresses {
+		if m.isMember(i) && (i == m.id || !m.excluded(i)) {
+			return i
+		}
+	}
+	return m.id
+}
+
+// deferMessage keeps a message from a process not in the current view of the group, to be handled
+// once the view that includes it is installed: the coordinator sends a new view to the members over
+// separate connections, so a process that joined the group may send its requests to a member
+// before the view reaches that member.
+func (m *Dimex) deferMessage(msg pp2plink.IndMsg) {
+	if len(m.backlog) >= maxBacklog {
+		m.log.WithField("from", msg.From).Warn("message from a process not in the group dropped: " + msg.Message)
+		return
+	}
+	m.log.WithField("from", msg.From).Debug("message from a process not in the view deferred: " + msg.Message)
+	m.backlog = append(m.backlog, msg)
+}
+
+// replayBacklog handles the messages kept from processes that were not in the view of the group,
+// in the order they were received. The ones from processes still not in the view are kept again.
+func (m *Dimex) replayBacklog() {
+	backlog := m.backlog
+	m.backlog = nil
+	for _, msg := range backlog {
+		m.handleMessage(msg)
+	}
+}
+
+// senderPID returns the PID of the process that sent the given message, as claimed in it. Requests
+// to join the group carry no PID and views always come from a member, so they are not checked
+// (PID 0). It returns false if the PID is malformed.
+func senderPID(message string) (int, bool) {
+	if kind := common.MessageKind(message); kind == JOIN || kind == VIEW {
+		return 0, true
+	}
+	parts := strings.Split(message, ";")
+	if len(parts) < 2 {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(parts[1])
+	return pid, err == nil && pid >= 0
+}
+
+func (m *Dimex) isMember(pid int) bool {
+	return pid < len(m.members) && m.members[pid]
+}
+
+// membersCopy returns the members of the group, for the snapshots, or nil if the group has not
+// changed since the start.
+func (m *Dimex) membersCopy() []bool {
+	if m.view == 0 {
+		return nil
+	}
+	return m.membersCopyAll()
+}
+
+func (m *Dimex) membersCopyAll() []bool {
+	members := make([]bool, len(m.members))
+	copy(members, m.members)
+	return members
+}
+
+// address returns the address of the process with the given PID. Unlike m.addresses, it is safe to
+// call outside the event loop.
+func (m *Dimex) address(pid int) string {
+	m.addrMu.RLock()
+	defer m.addrMu.RUnlock()
+	return m.addresses[pid]
+}
@@ -0,0 +1,75 @@
+package dimex
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJoinAndLeave(t *testing.T) {
+	group := newTestGroup(t, 2)
+
+	joined, err := JoinDimex(group[0].address(0), freeAddresses(t, 1)[0], testOpts()...)
+	if err != nil {
+		t.Fatalf("JoinDimex: %v", err)
+	}
+	if pid := joined.Inspect().PID; pid != 2 {
+		t.Fatalf("PID of the process that joined = %d, want 2", pid)
+	}
+	for _, m := range group {
+		eventually(t, "view with P2", func() bool { return m.Inspect().View == 1 })
+	}
+
+	// the process that joined needs the permissions of both members, and they need its own
+	group[1].Req <- ENTER
+	awaitInd(t, group[1])
+	joined.Req <- ENTER
+	assertNoInd(t, joined, 300*time.Millisecond)
+	group[1].Req <- EXIT
+	awaitInd(t, joined)
+	joined.Req <- EXIT
+
+	if err := group[1].Leave(); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	eventually(t, "view without P1", func() bool { return !group[0].Inspect().Members[1] })
+
+	// P1 no longer takes part in the mutual exclusion
+	group[0].Req <- ENTER
+	awaitInd(t, group[0])
+}
+
+func TestConflictingViewRejected(t *testing.T) {
+	group := newTestGroup(t, 3)
+	if err := group[2].Leave(); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	m := group[0]
+	eventually(t, "view without P2", func() bool { return m.Inspect().View == 1 })
+
+	addresses := m.addresses
+	tests := []struct {
+		name      string
+		view      int
+		addresses []string
+		members   []bool
+	}{
+		{"same number, other members", 1, addresses, []bool{true, false, false}},
+		{"address of a PID changed", 2, []string{addresses[0], addresses[2], addresses[2]}, []bool{true, true, false}},
+		{"addresses missing", 2, addresses[:2], []bool{true, true}},
+		{"process that left back", 2, addresses, []bool{true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.control(func() { m.installView(tt.view, tt.addresses, tt.members) })
+			if status := m.Inspect(); status.View != 1 || !status.Members[1] || status.Members[2] {
+				t.Errorf("view %d installed: view %d, members %v", tt.view, status.View, status.Members)
+			}
+		})
+	}
+
+	// a newer view that extends the installed one is installed
+	m.control(func() { m.installView(2, addresses, []bool{true, false, false}) })
+	if status := m.Inspect(); status.View != 2 || status.Members[1] {
+		t.Errorf("view 2 not installed: view %d, members %v", status.View, status.Members)
+	}
+}
@@ -0,0 +1,59 @@
+package dimex
+
+import "pucrs/sd/snapshots"
+
+// Observer is notified of the protocol events of a DIMEX module, e.g., to build metrics, tracing
+// or tests on top of the module without changing the algorithm. Its methods are called
+// synchronously from the event loop of the module, in the order the events happen, so they must
+// not block nor call back into the module (e.g., Inspect), which would deadlock it.
+//
+// Every method receives the PID of the process, so a single Observer can observe all processes.
+// Embed BaseObserver to implement only the methods of interest.
+type Observer interface {
+	// RequestSent is called when the process requests the CS, after broadcasting its reqEntry
+	// message with the given timestamp.
+	RequestSent(pid int, reqTs int)
+	// PermissionReceived is called when the process receives a respOk message from another
+	// process, with the number of permissions received so far for the current request.
+	PermissionReceived(pid int, from int, nbrResps int)
+	// CSEntered is called when the process enters the CS.
+	CSEntered(pid int)
+	// CSExited is called when the process exits the CS.
+	CSExited(pid int)
+	// SnapshotStarted is called when the process records its local state for a snapshot.
+	SnapshotStarted(pid int, snapId int)
+	// SnapshotCompleted is called when the local snapshot of the process is completed (all
+	// channels closed). The snapshot must not be modified.
+	SnapshotCompleted(pid int, snapshot *snapshots.Snapshot)
+	// InvariantViolated is called when the global snapshot completed by the process violates an
+	// invariant. It is only called when snapshots are verified online (see WithCollectorOpt), by
+	// the process that completes the global snapshot.
+	InvariantViolated(pid int, snapId int, err error)
+}
+
+// BaseObserver is an Observer that ignores all events. Embed it in an Observer to implement only
+// the methods of interest.
+type BaseObserver struct{}
+
+func (BaseObserver) RequestSent(pid int, reqTs int)                          {}
+func (BaseObserver) PermissionReceived(pid int, from int, nbrResps int)      {}
+func (BaseObserver) CSEntered(pid int)                                       {}
+func (BaseObserver) CSExited(pid int)                                        {}
+func (BaseObserver) SnapshotStarted(pid int, snapId int)                     {}
+func (BaseObserver) SnapshotCompleted(pid int, snapshot *snapshots.Snapshot) {}
+func (BaseObserver) InvariantViolated(pid int, snapId int, err error)        {}
+
+// WithObserverOpt is an option to notify the given observer of the protocol events. It can be
+// used multiple times, and the observers are notified in the order they were given.
+func WithObserverOpt(observer Observer) Opt {
+	return func(m *Dimex) {
+		m.observers = append(m.observers, observer)
+	}
+}
+
+// notify calls fn with each observer, in order.
+func (m *Dimex) notify(fn func(Observer)) {
+	for _, observer := range m.observers {
+		fn(observer)
+	}
+}
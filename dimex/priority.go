@@ -0,0 +1,81 @@
+package dimex
+
+// DefaultAgingTicks is the default number of Lamport clock ticks after which a request gains one
+// priority class (see WithAgingOpt)
+const DefaultAgingTicks = 10
+
+// ------------------------------------------------------------------------------------
+// ------- prioridades dos pedidos, com envelhecimento (aging)
+// ------- pedidos ordenados pela classe de prioridade e depois pelo timestamp de Lamport
+// ------------------------------------------------------------------------------------
+
+// request is a request to the CS, as ordered between the processes.
+type request struct {
+	ts       int // timestamp de Lamport do pedido
+	id       int // PID do processo
+	priority int // classe de prioridade (maior: mais urgente)
+}
+
+// WithAgingOpt is an option to set after how many Lamport clock ticks a request to the CS gains
+// one priority class over the requests issued after it (DefaultAgingTicks if not set), so requests
+// of low priority are not starved by a stream of requests of higher priority. The ticks must be
+// positive, and all processes must use the same value, so they order the requests in the same way.
+func WithAgingOpt(ticks int) Opt {
+	return func(m *Dimex) {
+		m.aging = ticks
+	}
+}
+
+// SetPriority sets the priority class of the next requests of the process to the CS (0 by
+// default). Requests of a higher class are granted the CS first, unless they are younger than a
+// request of a lower class by more than the aging of their difference in classes (see
+// WithAgingOpt), even if the process of the request of the lower class had already received the
+// permission of the process of the request of the higher class (see revokePermission).
+func (m *Dimex) SetPriority(priority int) {
+	m.control(func() { m.priority = priority })
+}
+
+// revokePermission gives back the permission of the process with the given PID, whose request is
+// ordered before the pending request of this process, which is ordered after it. The permission was
+// given before that process issued its request (e.g., when it did not want the CS), so both
+// processes hold the permission of the other one. The request of this process is sent again to that
+// process, which defers it until it exits the CS: the links are FIFO, so it arrives after the
+// permission just given to its request.
+func (m *Dimex) revokePermission(pid int) {
+	m.log.WithField("process", pid).Debug("permission given back to a request ordered before this one")
+	m.granted[pid] = false
+	m.nbrResps--
+	m.sendReqEntry(pid)
+}
+
+// yields tells whether the pending request of the process is ordered after the request of another
+// process with the given timestamp, PID and priority class.
+func (m *Dimex) yields(otherTs, otherId, otherPriority int) bool {
+	return after(
+		request{ts: m.reqTs, id: m.id, priority: m.reqPriority},
+		request{ts: otherTs, id: otherId, priority: otherPriority},
+		m.aging,
+	)
+}
+
+// after tells whether the request one is ordered after the request other, i.e., other has
+// precedence over it. A request of a higher priority class precedes the others, but each class
+// of difference is worth only the given aging in Lamport clock ticks: a request precedes a younger
+// request of a class up to (difference in ts / aging) above its own. Ties are broken by the
+// priority class, the timestamp and then by the PID, as in the original algorithm.
+func after(one, other request, aging int) bool {
+	oneRank, otherRank := rank(one.ts, one.priority, aging), rank(other.ts, other.priority, aging)
+	if oneRank != otherRank {
+		return oneRank > otherRank
+	}
+	if one.priority != other.priority {
+		return one.priority < other.priority
+	}
+	return one.ts > other.ts || (one.ts == other.ts && one.id > other.id)
+}
+
+// rank returns the rank of the request with the given timestamp and priority class: its timestamp
+// moved back by the aging of each class.
+func rank(ts int, priority int, aging int) int {
+	return ts - priority*aging
+}
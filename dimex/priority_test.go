@@ -0,0 +1,30 @@
+package dimex
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriorityOvertakesGrantedRequest(t *testing.T) {
+	group := newTestGroup(t, 3)
+
+	group[2].Req <- ENTER
+	awaitInd(t, group[2])
+
+	// P0 waits for P2, with the permission of P1, which did not want the CS
+	group[0].Req <- ENTER
+	eventually(t, "permission of P1", func() bool { return group[0].Inspect().NbrResps == 1 })
+
+	// the request of P1, of a higher class, is ordered before the one of P0, which gives back the
+	// permission of P1
+	group[1].SetPriority(5)
+	group[1].Req <- ENTER
+	eventually(t, "permission of P1 given back", func() bool { return group[0].Inspect().NbrResps == 0 })
+
+	group[2].Req <- EXIT
+	awaitInd(t, group[1])
+	assertNoInd(t, group[0], 300*time.Millisecond)
+
+	group[1].Req <- EXIT
+	awaitInd(t, group[0])
+}
@@ -0,0 +1,134 @@
+package dimex
+
+import (
+	"fmt"
+	"pucrs/sd/common"
+	"pucrs/sd/pp2plink"
+	"pucrs/sd/wal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recoveryRetryInterval is the time between retransmissions of the recover message to the
+// processes that have not answered it yet (e.g., because they were not listening yet)
+const recoveryRetryInterval = 500 * time.Millisecond
+
+// ------------------------------------------------------------------------------------
+// ------- recuperacao de falhas (crash-recovery), com o estado do log (WAL)
+// ------- UPON recover
+// ------- UPON recoverAck
+// ------------------------------------------------------------------------------------
+
+// restore restores the state recorded in the write-ahead log by a previous run, and starts the
+// recovery. A request to the CS interrupted by the crash is withdrawn, since the application that
+// issued it did not survive the crash, and the deferred replies are answered when the recovery ends.
+func (m *Dimex) restore(state wal.State) {
+	m.lcl = state.LocalClock
+	m.reqTs = state.ReqTs
+	m.recoverReqs = make([]bool, len(m.addresses))
+	for i, waiting := range state.Waiting {
+		if i < len(m.addresses) && i != m.id && waiting {
+			m.recoverReqs[i] = true
+		}
+	}
+	if state.State != common.NoMX {
+		m.log.WithFields(logrus.Fields{"state": state.State, "reqTs": state.ReqTs}).Warn("withdrawing the request to the CS interrupted by the crash")
+	}
+	m.st = common.NoMX
+
+	m.recovering = true
+	m.recoverAcks = make([]bool, len(m.addresses))
+	m.recoveryTicker = time.NewTicker(recoveryRetryInterval)
+	m.log.WithField("lcl", m.lcl).Info("recovering from the write-ahead log")
+}
+
+// sendRecover sends the recover message to the processes that have not answered it yet.
+func (m *Dimex) sendRecover() {
+	for i := range m.addresses {
+		if i != m.id && m.isMember(i) && !m.recoverAcks[i] && !m.excluded(i) {
+			m.sendToLink(m.addresses[i], fmt.Sprintf("%s;%d;%d", RECOVER, m.id, m.lcl))
+		}
+	}
+}
+
+/*
+upon event [ pl, Deliver | p, [ recover, r, rts ] ]  do
+
+	lts.ts := max(lts.ts, rts.ts)
+	postergados := postergados - [p, r]  // o pedido de p foi retirado
+	se estado == queroSC e p nao respondeu
+	então  trigger [ pl, Send | p , [ recoverAck, r, lts, myTs ] ]
+	senão  trigger [ pl, Send | p , [ recoverAck, r, lts, 0 ] ]
+*/
+func (m *Dimex) handleRecover(msg pp2plink.IndMsg) {
+	parts := strings.Split(msg.Message, ";")
+	otherId, _ := strconv.Atoi(parts[1])
+	otherLcl, _ := strconv.Atoi(parts[2])
+
+	m.lcl = max(m.lcl, otherLcl)
+	m.waiting[otherId] = false
+	m.persist()
+
+	pendingTs := 0
+	if m.st == common.WantMX && !m.granted[otherId] {
+		// o pedido corrente pode ter sido perdido pelo processo que falhou
+		pendingTs = m.reqTs
+		m.requested[otherId] = true
+	}
+	m.log.WithFields(logrus.Fields{"process": otherId, "pendingTs": pendingTs}).Info("process recovering from a crash")
+	m.sendToLink(m.addresses[otherId], fmt.Sprintf("%s;%d;%d;%d", RECOVER_ACK, m.id, m.lcl, pendingTs))
+}
+
+/*
+upon event [ pl, Deliver | p, [ recoverAck, r, rts, pts ] ]  do
+
+	lts.ts := max(lts.ts, rts.ts)
+	se pts > 0
+	então  pendentes := pendentes + [p, r]
+	se todos os processos nao suspeitos responderam
+	então  recuperando := false
+		para todo [p, r] em pendentes
+			trigger [ pl, Send | p , [ respOk, r ]  ]
+*/
+func (m *Dimex) handleRecoverAck(msg pp2plink.IndMsg) {
+	if !m.recovering {
+		return // resposta repetida a um recover reenviado
+	}
+	parts := strings.Split(msg.Message, ";")
+	otherId, _ := strconv.Atoi(parts[1])
+	otherLcl, _ := strconv.Atoi(parts[2])
+	pendingTs, _ := strconv.Atoi(parts[3])
+
+	m.lcl = max(m.lcl, otherLcl)
+	m.recoverAcks[otherId] = true
+	if pendingTs > 0 {
+		m.recoverReqs[otherId] = true
+	}
+	m.tryFinishRecovery()
+}
+
+// tryFinishRecovery ends the recovery if all other members of the group, except the ones excluded
+// by the perfect failure detector, have answered the recover message, and answers the requests that are pending.
+func (m *Dimex) tryFinishRecovery() {
+	if !m.recovering {
+		return
+	}
+	for i := range m.addresses {
+		if i != m.id && m.isMember(i) && !m.recoverAcks[i] && !m.excluded(i) {
+			return
+		}
+	}
+
+	m.recovering = false
+	m.recoveryTicker.Stop()
+	m.persist()
+	for i, pending := range m.recoverReqs {
+		if pending {
+			m.sendToLink(m.addresses[i], m.respOk())
+		}
+	}
+	m.log.WithField("lcl", m.lcl).Info("recovered from the write-ahead log")
+}
@@ -0,0 +1,244 @@
+/*
+  Modulo representando detectores de falhas baseados em heartbeats, tal como definidos em:
+    Introduction to Reliable and Secure Distributed Programming
+    Christian Cachin, Rachid Gerraoui, Luis Rodrigues
+  * Perfect Failure Detector (P): processo que nao envia heartbeat dentro do timeout e
+    suspeito para sempre (exclude on timeout). Requer um sistema sincrono.
+  * Eventually Perfect Failure Detector (<>P): suspeita pode ser revogada quando chega um
+    heartbeat do processo suspeito; nesse caso o timeout e aumentado (increasing timeout).
+*/
+
+package failuredetector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Mode is the kind of failure detector.
+type Mode int
+
+const (
+	// Perfect suspects a process forever once its heartbeats stop arriving within the timeout.
+	// It is only accurate if the timeout bounds the delays of the system (synchronous system).
+	Perfect Mode = iota
+	// EventuallyPerfect restores a suspected process when one of its heartbeats arrives, and
+	// increases the timeout of that process, so that false suspicions eventually stop.
+	EventuallyPerfect
+)
+
+// ParseMode parses the name of a mode (perfect or eventually-perfect).
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "perfect":
+		return Perfect, nil
+	case "eventually-perfect":
+		return EventuallyPerfect, nil
+	}
+	return Perfect, fmt.Errorf("ParseMode: unknown mode '%s'", name)
+}
+
+func (mode Mode) String() string {
+	if mode == EventuallyPerfect {
+		return "eventually-perfect"
+	}
+	return "perfect"
+}
+
+// Config configures a failure detector.
+type Config struct {
+	Mode     Mode
+	Interval time.Duration // time between heartbeats sent to each process
+	Timeout  time.Duration // time without heartbeats after which a process is suspected
+	Delta    time.Duration // increase of the timeout of a process after a false suspicion (EventuallyPerfect)
+}
+
+// DefaultConfig returns the default configuration of the given mode.
+func DefaultConfig(mode Mode) Config {
+	return Config{
+		Mode:     mode,
+		Interval: 100 * time.Millisecond,
+		Timeout:  500 * time.Millisecond,
+		Delta:    250 * time.Millisecond,
+	}
+}
+
+// Event is an indication of the failure detector: a process is suspected to have crashed, or a
+// suspected process is restored (only with EventuallyPerfect).
+type Event struct {
+	PID       int
+	Suspected bool
+}
+
+// Detector is a heartbeat-based failure detector of a process. It sends heartbeats to all other
+// processes with the given send function (e.g., through a PP2PLink), is told of the heartbeats
+// received with Heartbeat, and indicates suspicions and restorations on the Ind channel.
+type Detector struct {
+	Ind chan Event // canal para informar o modulo de cima das suspeitas (e restauracoes)
+
+	mu        sync.Mutex
+	cfg       Config
+	pid       int
+	send      func(to int) // envia um heartbeat para o processo com o PID dado
+	lastHeard []time.Time  // quando chegou o ultimo heartbeat de cada processo
+	timeouts  []time.Duration
+	suspected []bool
+	restored  []bool // chegou heartbeat de processo suspeito (restaurado na proxima verificacao)
+	members   []bool // processos monitorados (membros do grupo); nil se todos
+	stop      chan struct{}
+}
+
+// NewDetector creates a new instance of a Detector for the process with the given PID, in a system
+// with n processes. The user is responsible for calling Start() to start sending heartbeats and
+// detecting failures, and Stop() to stop it.
+func NewDetector(pid, n int, cfg Config, send func(to int)) *Detector {
+	d := &Detector{
+		Ind:       make(chan Event, n),
+		cfg:       cfg,
+		pid:       pid,
+		send:      send,
+		lastHeard: make([]time.Time, n),
+		timeouts:  make([]time.Duration, n),
+		suspected: make([]bool, n),
+		restored:  make([]bool, n),
+		stop:      make(chan struct{}),
+	}
+	for i := range d.timeouts {
+		d.timeouts[i] = cfg.Timeout
+	}
+	return d
+}
+
+// Start starts sending heartbeats and checking the heartbeats received, in the background. The
+// timeouts start counting when Start is called, so all processes have the same time to start.
+func (d *Detector) Start() {
+	d.mu.Lock()
+	now := time.Now()
+	for i := range d.lastHeard {
+		d.lastHeard[i] = now
+	}
+	d.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(d.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.sendHeartbeats()
+				d.check()
+			}
+		}
+	}()
+}
+
+// Stop stops sending heartbeats and checking the heartbeats received.
+func (d *Detector) Stop() {
+	close(d.stop)
+}
+
+// Heartbeat records a heartbeat received from the process with the given PID. With
+// EventuallyPerfect, if the process is suspected, it is restored (on the next check) and its
+// timeout is increased. Heartbeat never blocks, so it can be called from the module that
+// consumes the Ind channel.
+func (d *Detector) Heartbeat(from int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if from < 0 || from >= len(d.lastHeard) {
+		return
+	}
+	if d.suspected[from] {
+		if d.cfg.Mode == Perfect {
+			return // a suspicion of a perfect failure detector is permanent
+		}
+		d.restored[from] = true
+	}
+	d.lastHeard[from] = time.Now()
+}
+
+// SetMembers sets the processes monitored by the detector (i.e., the members of the group), by PID,
+// which may include processes that joined the group after the detector was created. Heartbeats are
+// neither sent to nor expected from the other processes.
+func (d *Detector) SetMembers(members []bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for len(d.lastHeard) < len(members) {
+		d.lastHeard = append(d.lastHeard, now)
+		d.timeouts = append(d.timeouts, d.cfg.Timeout)
+		d.suspected = append(d.suspected, false)
+		d.restored = append(d.restored, false)
+	}
+	for i, member := range members {
+		if member && !d.isMember(i) {
+			d.lastHeard[i] = now // a process that joins has the whole timeout to send its heartbeats
+		}
+	}
+	d.members = make([]bool, len(members))
+	copy(d.members, members)
+}
+
+// Suspected returns the processes currently suspected, by PID.
+func (d *Detector) Suspected() []bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	suspected := make([]bool, len(d.suspected))
+	copy(suspected, d.suspected)
+	return suspected
+}
+
+func (d *Detector) sendHeartbeats() {
+	d.mu.Lock()
+	to := make([]int, 0, len(d.lastHeard))
+	for i := range d.lastHeard {
+		if i != d.pid && d.isMember(i) {
+			to = append(to, i)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, i := range to {
+		d.send(i)
+	}
+}
+
+func (d *Detector) isMember(pid int) bool {
+	return d.members == nil || (pid < len(d.members) && d.members[pid])
+}
+
+// check suspects the processes whose heartbeats did not arrive within their timeout, and restores
+// the suspected processes whose heartbeats arrived in the meantime. The events are indicated after
+// releasing the lock, so Heartbeat does not block while the Ind channel is full.
+func (d *Detector) check() {
+	events := make([]Event, 0)
+
+	d.mu.Lock()
+	now := time.Now()
+	for i := range d.lastHeard {
+		if i == d.pid || !d.isMember(i) {
+			continue
+		}
+		if d.suspected[i] && d.restored[i] {
+			d.suspected[i], d.restored[i] = false, false
+			d.timeouts[i] += d.cfg.Delta
+			events = append(events, Event{PID: i, Suspected: false})
+		} else if !d.suspected[i] && now.Sub(d.lastHeard[i]) > d.timeouts[i] {
+			d.suspected[i] = true
+			events = append(events, Event{PID: i, Suspected: true})
+		}
+	}
+	d.mu.Unlock()
+
+	for _, event := range events {
+		select {
+		case d.Ind <- event:
+		case <-d.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package fencing
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// pidBits is the number of low-order bits of a Token that hold the PID of the process
+	pidBits = 16
+	// counterBits is the number of bits of a Token, above the PID, that hold the counter
+	counterBits = 32
+)
+
+// ErrStaleToken is returned by a Validator when a token is lower than the highest token it has
+// seen, i.e., it was handed to a former holder of the critical section.
+var ErrStaleToken = errors.New("stale fencing token")
+
+// Token is a fencing token, handed to the application on each entry in the critical section. It is
+// made of an epoch and a counter agreed across the processes, and the PID of the process: each
+// permission to enter the critical section carries the highest token known by its sender, and an
+// entry takes a counter above all of them. A conflicting former holder only gives its permission
+// once it exits, so the tokens of consecutive entries are monotonically increasing (except among
+// readers holding it together), whatever the order in which the requests are granted. A resource
+// that rejects tokens lower than the highest it has seen is protected against a delayed former
+// holder (e.g., one whose lease expired).
+//
+// An entry without the permission of a process (e.g., suspected to have crashed) takes a new epoch
+// instead: that process may have held the critical section with a token it never passed on, and
+// every token of a new epoch is above the ones of the previous epochs, whatever their counters.
+type Token int64
+
+// NewToken creates the token with the given counter of the entry in the critical section of the
+// process with the given PID, in the first epoch.
+func NewToken(counter int, pid int) Token {
+	return NewEpochToken(0, counter, pid)
+}
+
+// NewEpochToken creates the token with the given epoch and counter of the entry in the critical
+// section of the process with the given PID.
+func NewEpochToken(epoch int, counter int, pid int) Token {
+	return Token(int64(epoch)<<(counterBits+pidBits) | int64(counter)<<pidBits | int64(pid))
+}
+
+// Next returns the token of the next entry in the critical section of the process with the given
+// PID, above the token t (the highest known by the process).
+func (t Token) Next(pid int) Token {
+	return NewEpochToken(t.Epoch(), t.Counter()+1, pid)
+}
+
+// NextEpoch returns the token of the next entry in the critical section of the process with the
+// given PID in a new epoch, above the token t and any other token of its epoch.
+func (t Token) NextEpoch(pid int) Token {
+	return NewEpochToken(t.Epoch()+1, 1, pid)
+}
+
+// Epoch returns the epoch of the token.
+func (t Token) Epoch() int {
+	return int(int64(t) >> (counterBits + pidBits))
+}
+
+// Counter returns the counter of the token.
+func (t Token) Counter() int {
+	return int(int64(t) >> pidBits & (1<<counterBits - 1))
+}
+
+// PID returns the PID of the process of the token.
+func (t Token) PID() int {
+	return int(int64(t) & (1<<pidBits - 1))
+}
+
+func (t Token) String() string {
+	if t.Epoch() == 0 {
+		return fmt.Sprintf("%d.%d", t.Counter(), t.PID())
+	}
+	return fmt.Sprintf("%d:%d.%d", t.Epoch(), t.Counter(), t.PID())
+}
+
+// Validator validates the fencing tokens of the accesses to a resource, on the resource side. It is
+// safe for concurrent use.
+type Validator struct {
+	mu               sync.Mutex
+	highest          Token // highest token seen
+	highestExclusive Token // highest token of an exclusive access seen
+}
+
+// NewValidator creates a new Validator, which has not seen any token yet.
+func NewValidator() *Validator {
+	return &Validator{}
+}
+
+// Guard runs the given exclusive access (a writer) to the resource if the token is not lower than
+// the highest token seen so far (the same holder may access the resource many times), and records
+// the token. The check and the access are atomic with respect to the other accesses guarded by the
+// Validator. It returns an error wrapping ErrStaleToken if the token is stale, or the error of the
+// access.
+func (v *Validator) Guard(token Token, access func() error) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if token < v.highest {
+		return fmt.Errorf("validator.Guard: token %v lower than %v: %w", token, v.highest, ErrStaleToken)
+	}
+	v.highest = token
+	v.highestExclusive = token
+	return access()
+}
+
+// GuardShared is like Guard for a shared access (a reader), which only conflicts with the exclusive
+// accesses: its token must not be lower than the highest token of an exclusive access, since the
+// readers that hold the critical section together may access the resource in any order.
+func (v *Validator) GuardShared(token Token, access func() error) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if token < v.highestExclusive {
+		return fmt.Errorf("validator.GuardShared: token %v lower than %v: %w", token, v.highestExclusive, ErrStaleToken)
+	}
+	if token > v.highest {
+		v.highest = token
+	}
+	return access()
+}
+
+// Highest returns the highest token seen so far (0 if none).
+func (v *Validator) Highest() Token {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.highest
+}
@@ -0,0 +1,36 @@
+package fencing
+
+import "testing"
+
+func TestTokenOrder(t *testing.T) {
+	tests := []struct {
+		name          string
+		lower, higher Token
+	}{
+		{"next counter", NewToken(3, 2), NewToken(3, 2).Next(1)},
+		{"same counter, higher PID", NewToken(3, 1), NewToken(3, 2)},
+		{"next epoch above a higher counter", NewToken(1000, 2), NewToken(5, 1).NextEpoch(1)},
+		{"next counter in an epoch", NewEpochToken(1, 1, 0), NewEpochToken(1, 1, 0).Next(0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.lower >= tt.higher {
+				t.Errorf("token %v not lower than %v", tt.lower, tt.higher)
+			}
+		})
+	}
+}
+
+func TestTokenParts(t *testing.T) {
+	token := NewEpochToken(2, 7, 3)
+	if token.Epoch() != 2 || token.Counter() != 7 || token.PID() != 3 {
+		t.Errorf("NewEpochToken(2, 7, 3) = epoch %d, counter %d, PID %d", token.Epoch(), token.Counter(), token.PID())
+	}
+	if got := token.String(); got != "2:7.3" {
+		t.Errorf("String() = %q, want %q", got, "2:7.3")
+	}
+	if got := NewToken(7, 3).String(); got != "7.3" {
+		t.Errorf("String() = %q, want %q", got, "7.3")
+	}
+}
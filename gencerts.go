@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"pucrs/sd/pp2plink"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// certValidity is for how long the certificates generated by the "gencerts" subcommand are valid
+	certValidity = 365 * 24 * time.Hour
+	// caKeyFile is the file with the key of the CA, next to its certificate (pp2plink.CAFile): only
+	// gencerts needs it, to sign the certificates of processes joining the group later
+	caKeyFile = "ca-key.pem"
+)
+
+// gencerts implements the "gencerts" subcommand, which generates a local test CA (or reuses the one
+// in the output directory) and a certificate signed by it for each process, to connect the
+// processes with mutual TLS (see the -tls flag)
+func gencerts(args []string) {
+	fs := flag.NewFlagSet("gencerts", flag.ExitOnError)
+	outDir := fs.String("out", "certs", "Directory to write the CA and the certificates of the processes to")
+	fs.Parse(args)
+
+	addresses := fs.Args()
+	if len(addresses) == 0 {
+		logrus.Errorf("Usage: %s gencerts [-out <dir>] <address:port> [<address:port>...]", os.Args[0])
+		os.Exit(1)
+	}
+
+	if err := generateCerts(*outDir, addresses); err != nil {
+		logrus.Errorf("Failed to generate certificates: %v", err)
+		os.Exit(1)
+	}
+	logrus.Infof("Generated the certificates of %d processes in '%s', signed by the CA in it", len(addresses), *outDir)
+}
+
+// generateCerts writes, for each address, the certificate and the key of the process listening on
+// it to dir, signed by the CA in dir: its certificate and its key (caKeyFile) are reused if both
+// are there, otherwise a new CA is generated and both are written. The identity of each process,
+// its address, is the CommonName of its certificate, which is valid both to accept and to open
+// connections.
+func generateCerts(dir string, addresses []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	caCert, caKey, err := loadOrGenerateCA(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("invalid address '%s': %w", address, err)
+		}
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		template := certTemplate(address)
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = []net.IP{ip}
+		} else {
+			template.DNSNames = []string{host}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			return err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+
+		certFile, keyFile := pp2plink.CertFiles(dir, address)
+		if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+			return err
+		}
+		if err := writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadOrGenerateCA returns the CA whose certificate and key are in dir. If neither is there, it
+// generates a new CA and writes both, the key readable only by its owner; if only one of them is
+// there, it fails rather than overwrite it.
+func loadOrGenerateCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPath, keyPath := filepath.Join(dir, pp2plink.CAFile), filepath.Join(dir, caKeyFile)
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	switch {
+	case certErr == nil && keyErr == nil:
+		return parseCA(certPEM, keyPEM)
+	case !errors.Is(certErr, os.ErrNotExist) && certErr != nil:
+		return nil, nil, certErr
+	case !errors.Is(keyErr, os.ErrNotExist) && keyErr != nil:
+		return nil, nil, keyErr
+	case certErr == nil:
+		return nil, nil, fmt.Errorf("CA certificate '%s' without its key '%s': remove it to generate a new CA", certPath, keyPath)
+	case keyErr == nil:
+		return nil, nil, fmt.Errorf("CA key '%s' without its certificate '%s': remove it to generate a new CA", keyPath, certPath)
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	caTemplate := certTemplate("DiMEx test CA")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, nil, err
+	}
+	caKeyDER, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(keyPath, "EC PRIVATE KEY", caKeyDER, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certPath, "CERTIFICATE", caDER, 0644); err != nil {
+		return nil, nil, err
+	}
+	return caCert, caKey, nil
+}
+
+// parseCA parses the certificate and the key of a CA, in PEM.
+func parseCA(certPEM []byte, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM block in the CA certificate")
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CA certificate: %w", err)
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM block in the CA key")
+	}
+	caKey, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CA key: %w", err)
+	}
+	if !caKey.PublicKey.Equal(caCert.PublicKey) {
+		return nil, nil, fmt.Errorf("the CA key does not match the CA certificate")
+	}
+	return caCert, caKey, nil
+}
+
+// certTemplate returns the template of a certificate with the given CommonName, valid from now on
+func certTemplate(commonName string) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(certValidity),
+	}
+}
+
+func writePEM(path string, blockType string, der []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
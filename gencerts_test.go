@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"pucrs/sd/pp2plink"
+	"testing"
+)
+
+// verifyCert checks that the certificate of the process at the given address, in dir, is signed by
+// the CA in dir and matches its key.
+func verifyCert(t *testing.T, dir string, address string) {
+	t.Helper()
+	caPEM, err := os.ReadFile(filepath.Join(dir, pp2plink.CAFile))
+	if err != nil {
+		t.Fatalf("reading the CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		t.Fatalf("no certificate in %s", pp2plink.CAFile)
+	}
+
+	certFile, keyFile := pp2plink.CertFiles(dir, address)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("certificate of %s: %v", address, err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatalf("certificate of %s: %v", address, err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Errorf("certificate of %s not signed by the CA: %v", address, err)
+	}
+}
+
+func TestGenerateCertsReusesCA(t *testing.T) {
+	dir := t.TempDir()
+	if err := generateCerts(dir, []string{"127.0.0.1:5000", "127.0.0.1:6001"}); err != nil {
+		t.Fatalf("generateCerts: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, caKeyFile))
+	if err != nil {
+		t.Fatalf("key of the CA: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("permissions of %s = %v, want %v", caKeyFile, perm, os.FileMode(0600))
+	}
+	caPEM, _ := os.ReadFile(filepath.Join(dir, pp2plink.CAFile))
+
+	// a process joining later gets a certificate signed by the same CA
+	if err := generateCerts(dir, []string{"127.0.0.1:7002"}); err != nil {
+		t.Fatalf("generateCerts with the existing CA: %v", err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(dir, pp2plink.CAFile)); string(again) != string(caPEM) {
+		t.Errorf("%s rewritten, want the existing CA reused", pp2plink.CAFile)
+	}
+	for _, address := range []string{"127.0.0.1:5000", "127.0.0.1:6001", "127.0.0.1:7002"} {
+		verifyCert(t, dir, address)
+	}
+}
+
+func TestGenerateCertsRejectsCAWithoutKey(t *testing.T) {
+	dir := t.TempDir()
+	if err := generateCerts(dir, []string{"127.0.0.1:5000"}); err != nil {
+		t.Fatalf("generateCerts: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, caKeyFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateCerts(dir, []string{"127.0.0.1:6001"}); err == nil {
+		t.Errorf("generateCerts with a CA certificate without its key succeeded, want an error")
+	}
+
+	// a key that does not match the certificate of the CA is rejected too
+	other := t.TempDir()
+	if err := generateCerts(other, []string{"127.0.0.1:5000"}); err != nil {
+		t.Fatalf("generateCerts: %v", err)
+	}
+	keyPEM, _ := os.ReadFile(filepath.Join(other, caKeyFile))
+	if err := os.WriteFile(filepath.Join(dir, caKeyFile), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateCerts(dir, []string{"127.0.0.1:6001"}); err == nil {
+		t.Errorf("generateCerts with the key of another CA succeeded, want an error")
+	}
+}
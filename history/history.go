@@ -0,0 +1,259 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"pucrs/sd/fencing"
+	"sync"
+	"time"
+)
+
+// Kinds of entries in the critical section history.
+const (
+	ENTER string = "enter"
+	EXIT  string = "exit"
+)
+
+// Entry is an entry in the critical section history, recorded by a process when it enters or
+// exits the critical section.
+type Entry struct {
+	Kind string // ENTER or EXIT
+	PID  int    // PID of the process
+	Seq  int    // sequence number of the access of the process to the critical section (from 1)
+	Ts   int64  // when the process entered or exited the critical section (Unix time in nanoseconds)
+
+	// Fencing token of the access (0 if the process did not hand it over to the Writer)
+	Token fencing.Token `json:",omitempty"`
+
+	// Whether the access is shared (a reader, which may overlap with other readers)
+	Shared bool `json:",omitempty"`
+}
+
+// Writer records the accesses of a process to the critical section in the history file, which is
+// shared by all processes (and is itself the resource protected by the critical section).
+type Writer struct {
+	mu        sync.Mutex
+	file      *os.File
+	pid       int
+	seq       int
+	token     fencing.Token      // fencing token of the current access
+	shared    bool               // whether the current access is shared (reader)
+	validator *fencing.Validator // rejects the entries of a former holder of the critical section (optional)
+}
+
+// WriterOpt is an option to customize a Writer.
+type WriterOpt func(*Writer)
+
+// WithValidatorOpt is an option to validate the fencing tokens of the entries with the given
+// validator, shared by the Writers of all processes: the entries of an access whose token is lower
+// than the token of an access already recorded (i.e., of a former holder of the critical section,
+// e.g., whose lease expired) are rejected with an error wrapping fencing.ErrStaleToken.
+func WithValidatorOpt(validator *fencing.Validator) WriterOpt {
+	return func(w *Writer) {
+		w.validator = validator
+	}
+}
+
+// NewWriter creates a new instance of a Writer for the process with the given PID, which appends
+// its entries to the history file at the given path. The user is responsible for calling Close()
+// after all operations are completed to ensure proper resource management.
+func NewWriter(path string, pid int, opts ...WriterOpt) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("NewWriter: failed opening '%s' file: %w", path, err)
+	}
+	w := &Writer{file: file, pid: pid}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w, nil
+}
+
+// Enter records that the process entered the critical section with the given fencing token, in
+// shared (reader) or exclusive (writer) mode, starting a new access. A rejected access is not
+// recorded.
+func (w *Writer) Enter(token fencing.Token, shared bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.token = token
+	w.shared = shared
+	return w.guard(func() error {
+		w.seq++
+		return w.write(ENTER)
+	})
+}
+
+// Exit records that the process exited the critical section, ending the current access.
+func (w *Writer) Exit() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.guard(func() error {
+		return w.write(EXIT)
+	})
+}
+
+// guard records an entry of the current access, validating its token if there is a validator.
+func (w *Writer) guard(record func() error) error {
+	if w.validator == nil {
+		return record()
+	}
+	guard := w.validator.Guard
+	if w.shared {
+		guard = w.validator.GuardShared
+	}
+	if err := guard(w.token, record); err != nil {
+		return fmt.Errorf("writer.guard (pid %d): %w", w.pid, err)
+	}
+	return nil
+}
+
+// Close closes the history file.
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
+
+func (w *Writer) write(kind string) error {
+	entryJson, err := json.Marshal(Entry{Kind: kind, PID: w.pid, Seq: w.seq, Ts: time.Now().UnixNano(), Token: w.token, Shared: w.shared})
+	if err != nil {
+		return fmt.Errorf("writer.write: failed marshaling entry to JSON: %w", err)
+	}
+
+	// a single write of the whole line, so that entries of different processes are not interleaved
+	if _, err := w.file.Write(append(entryJson, '\n')); err != nil {
+		return fmt.Errorf("writer.write: failed writing to '%s' file: %w", w.file.Name(), err)
+	}
+	return nil
+}
+
+// accessKey identifies an access to the critical section: the PID of the process and the sequence
+// number of the access.
+type accessKey struct {
+	pid, seq int
+}
+
+// Verify checks the critical section history file at the given path, as an end-to-end check of
+// mutual exclusion that is independent from the snapshots. It verifies that:
+//   - each exit matches an access in the critical section (same process and sequence number);
+//   - the sequence numbers of the accesses of each process are consecutive;
+//   - the critical section intervals do not overlap, except for shared accesses (readers): each
+//     exit happens after the matching entry, and each entry happens after the preceding exits of
+//     the accesses it conflicts with;
+//   - the fencing tokens of the accesses (if recorded) are increasing, except among shared accesses.
+//
+// An access whose token is higher than the token of a conflicting access in the critical section
+// fences the latter off, e.g., after its lease expired: the history is then valid as long as the fenced
+// access records nothing else (i.e., the resource rejected its later entries).
+//
+// The last access may not have been exited, since the execution may be interrupted in the middle
+// of it. Verify returns the first violation found, or nil if there is none.
+func Verify(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Verify: failed to open file '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	var open []Entry                   // accesses in the critical section (many only if all are shared)
+	var lastExit, lastWriteExit *Entry // latest exits of any access and of an exclusive access, if any
+	var lastToken, lastWriteToken fencing.Token
+	lastSeq := make(map[int]int)
+	fenced := make(map[accessKey]Entry) // accesses fenced off, and the entries that fenced them off
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("Verify (line %d): error unmarshaling entry: %w", lineNo, err)
+		}
+
+		switch entry.Kind {
+		case ENTER:
+			// a shared access only conflicts with the exclusive ones
+			bound, prevExit := lastToken, lastExit
+			if entry.Shared {
+				bound, prevExit = lastWriteToken, lastWriteExit
+			}
+			if entry.Token != 0 && bound != 0 && entry.Token <= bound {
+				return fmt.Errorf(
+					"Verify (line %d): process %d entered the CS (seq %d) with token %v, not greater than token %v",
+					lineNo, entry.PID, entry.Seq, entry.Token, bound,
+				)
+			}
+			stillOpen := open[:0]
+			for _, other := range open {
+				if entry.Shared && other.Shared {
+					stillOpen = append(stillOpen, other)
+					continue
+				}
+				if other.Token == 0 || entry.Token <= other.Token {
+					return fmt.Errorf(
+						"Verify (line %d): process %d entered the CS (seq %d) while process %d is in it (seq %d)",
+						lineNo, entry.PID, entry.Seq, other.PID, other.Seq,
+					)
+				}
+				fenced[accessKey{other.PID, other.Seq}] = entry
+			}
+			open = stillOpen
+			if entry.Seq != lastSeq[entry.PID]+1 {
+				return fmt.Errorf(
+					"Verify (line %d): process %d entered the CS with seq %d after seq %d",
+					lineNo, entry.PID, entry.Seq, lastSeq[entry.PID],
+				)
+			}
+			if prevExit != nil && entry.Ts < prevExit.Ts {
+				return fmt.Errorf(
+					"Verify (line %d): process %d entered the CS (seq %d) %v before process %d exited it (seq %d)",
+					lineNo, entry.PID, entry.Seq, time.Duration(prevExit.Ts-entry.Ts), prevExit.PID, prevExit.Seq,
+				)
+			}
+			lastSeq[entry.PID] = entry.Seq
+			if entry.Token > lastToken {
+				lastToken = entry.Token
+			}
+			if !entry.Shared {
+				lastWriteToken = entry.Token
+			}
+			open = append(open, entry)
+		case EXIT:
+			if by, ok := fenced[accessKey{entry.PID, entry.Seq}]; ok {
+				return fmt.Errorf(
+					"Verify (line %d): process %d exited the CS (seq %d) after process %d fenced it off (seq %d, token %v)",
+					lineNo, entry.PID, entry.Seq, by.PID, by.Seq, by.Token,
+				)
+			}
+			i := 0
+			for i < len(open) && (open[i].PID != entry.PID || open[i].Seq != entry.Seq) {
+				i++
+			}
+			if i == len(open) {
+				return fmt.Errorf(
+					"Verify (line %d): process %d exited the CS (seq %d) without having entered it",
+					lineNo, entry.PID, entry.Seq,
+				)
+			}
+			if entry.Ts < open[i].Ts {
+				return fmt.Errorf(
+					"Verify (line %d): process %d exited the CS (seq %d) before entering it",
+					lineNo, entry.PID, entry.Seq,
+				)
+			}
+			exit := entry
+			if lastExit == nil || exit.Ts > lastExit.Ts {
+				lastExit = &exit
+			}
+			if !open[i].Shared {
+				lastWriteExit = &exit
+			}
+			open = append(open[:i], open[i+1:]...)
+		default:
+			return fmt.Errorf("Verify (line %d): unknown entry kind '%s'", lineNo, entry.Kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Verify: failed reading file '%s': %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Subsystems that have their own logger, so their levels can be set independently.
+const (
+	DIMEX     string = "dimex"
+	PP2PLINK  string = "pp2plink"
+	SNAPSHOTS string = "snapshots"
+)
+
+// Config configures the loggers of the subsystems.
+type Config struct {
+	JSON   bool                    // write entries as JSON objects (one per line) instead of text
+	Level  logrus.Level            // level of the subsystems without a level of their own
+	Levels map[string]logrus.Level // level of each subsystem, by name
+}
+
+// ParseLevels parses the levels of the subsystems, given as a comma-separated list of
+// <subsystem>=<level> pairs (e.g., "dimex=debug,pp2plink=warn").
+func ParseLevels(spec string) (map[string]logrus.Level, error) {
+	levels := make(map[string]logrus.Level)
+	if strings.TrimSpace(spec) == "" {
+		return levels, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		subsystem, levelSpec, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return nil, fmt.Errorf("ParseLevels: expected '<subsystem>=<level>', found '%s'", pair)
+		}
+		switch subsystem {
+		case DIMEX, PP2PLINK, SNAPSHOTS:
+		default:
+			return nil, fmt.Errorf("ParseLevels: unknown subsystem '%s'", subsystem)
+		}
+		level, err := logrus.ParseLevel(levelSpec)
+		if err != nil {
+			return nil, fmt.Errorf("ParseLevels: invalid level of subsystem '%s': %w", subsystem, err)
+		}
+		levels[subsystem] = level
+	}
+
+	return levels, nil
+}
+
+// Formatter returns the formatter of the entries, as configured.
+func (cfg Config) Formatter() logrus.Formatter {
+	if cfg.JSON {
+		return &logrus.JSONFormatter{}
+	}
+	return &logrus.TextFormatter{}
+}
+
+// Logger creates the logger of the given subsystem, whose entries have the name of the subsystem
+// in the "subsystem" field. Further fields (e.g., the PID of the process) are added by the
+// subsystem itself.
+func (cfg Config) Logger(subsystem string) *logrus.Entry {
+	level, ok := cfg.Levels[subsystem]
+	if !ok {
+		level = cfg.Level
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetFormatter(cfg.Formatter())
+	logger.SetLevel(level)
+
+	return logger.WithField("subsystem", subsystem)
+}
@@ -1,12 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"pucrs/sd/admin"
 	"pucrs/sd/dimex"
+	"pucrs/sd/failuredetector"
+	"pucrs/sd/fencing"
+	"pucrs/sd/history"
+	"pucrs/sd/logging"
+	"pucrs/sd/metrics"
+	"pucrs/sd/pp2plink"
 	"pucrs/sd/snapshots"
+	"pucrs/sd/trace"
+	"pucrs/sd/wal"
+	"pucrs/sd/workload"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,30 +35,104 @@ const (
 	BOLD_RED     = "\033[1;31m"
 	BOLD_REGULAR = "\033[1m"
 	RESET        = "\033[0m"
+
+	HISTORY_FILE = "./mxOUT.txt" // file that all processes write to in the CS (critical section history)
 )
 
 var (
 	verboseMode = flag.Bool("v", false, "Enable verbose (debug) logging for snapshots")
-	failureMode = flag.Bool("f", false, "Enable failure simulation in the DiMEx module")
+	logJSON     = flag.Bool("log-json", false, "Write log entries as JSON objects (one per line)")
+	logLevels   = flag.String("log-level", "", "Comma-separated log level of each subsystem (dimex, pp2plink, snapshots), e.g., dimex=debug,pp2plink=warn")
+	failureMode = flag.Bool("f", false, "Enable failure simulation in the DiMEx module (permissions are never delayed, so mutual exclusion is violated)")
 	snapshotSec = flag.Float64("s", 0.5, "Interval in which snapshots are taken (in seconds)")
+	exprsFile   = flag.String("i", "", "File with additional invariant expressions to be checked on the snapshots")
+	starvationK = flag.Int("k", 0, "Report processes waiting for the CS for more than k consecutive snapshots (0 disables)")
+	bypassN     = flag.Int("bypass", 0, "Report requests to the CS bypassed by more than n requests ordered after them while waiting (0 disables)")
+	onlineMode  = flag.Bool("o", false, "Verify the snapshots online, as soon as they are completed")
+	haltMode    = flag.Bool("halt", false, "Halt the system on the first invariant violation found online (requires -o)")
+	dumpMode    = flag.Bool("dump", false, "Dump the snapshots that violate an invariant online to a file (requires -o)")
+	dotFile     = flag.String("dot", "", "File to write a DOT (Graphviz) rendering of the snapshots that violate an invariant")
+	traceMode   = flag.Bool("t", false, "Record a trace of the protocol events (ShiViz log and Chrome trace)")
+	metricsAddr = flag.String("metrics", "", "Address (host:port) of the /metrics endpoint of the first process; process i listens on port+i")
+	adminAddr   = flag.String("admin", "", "Address (host:port) of the HTTP admin API of the first process; process i listens on port+i")
+	fdMode      = flag.String("fd", "", "Failure detector of crashed processes (perfect, which excludes them from the quorum, or eventually-perfect; disabled if empty)")
+	fdInterval  = flag.Duration("fd-interval", 100*time.Millisecond, "Time between heartbeats of the failure detector")
+	fdTimeout   = flag.Duration("fd-timeout", 500*time.Millisecond, "Time without heartbeats after which the failure detector suspects a process")
+	fdDelta     = flag.Duration("fd-delta", 250*time.Millisecond, "Increase of the timeout after a false suspicion (eventually-perfect failure detector)")
+	walDir      = flag.String("wal", "", "Directory of the write-ahead logs of the processes, to recover their state after a crash (disabled if empty)")
+	lease       = flag.Duration("lease", 0, "Lease of the CS, after which a process still in it is taken out of it (0 for no lease)")
+	fenceMode   = flag.Bool("fence", false, "Validate the fencing tokens of the accesses to the CS history, rejecting those of former holders")
+	priorities  = flag.String("priorities", "", "Comma-separated priority class of the requests to the CS of each process (e.g., 0,0,2)")
+	authKeyFile = flag.String("auth-key", "", "File with the key shared by all processes to authenticate their messages with an HMAC (disabled if empty)")
+	tlsDir      = flag.String("tls", "", "Directory with the CA and the certificates of the processes, to connect them with mutual TLS (disabled if empty)")
+	reliable    = flag.Bool("reliable", false, "Retransmit the messages between processes until acknowledged, delivered once and in order (the oldest are lost beyond 1024 unacknowledged)")
+	aging       = flag.Int("aging", dimex.DefaultAgingTicks, "Lamport clock ticks after which a request to the CS gains one priority class")
+
+	warmup    = flag.Duration("warmup", 2*time.Second, "Time to wait before the first request to the CS")
+	think     = flag.String("think", "0s", "Distribution of the time between an exit from the CS and the next request (e.g., 10ms, uniform:5ms-20ms, exp:10ms)")
+	hold      = flag.String("hold", "0s", "Distribution of the time inside the CS (e.g., 10ms, uniform:5ms-20ms, exp:10ms)")
+	pattern   = flag.String("pattern", "steady", "Pattern of the requests to the CS (steady, bursty or skewed)")
+	burstSize = flag.Int("burst", 5, "Number of back-to-back requests in a burst (with the bursty pattern)")
+	rates     = flag.String("rates", "", "Comma-separated relative request rate of each process (e.g., 1,1,4)")
+	readRatio = flag.Float64("read-ratio", 0, "Fraction of the requests to the CS issued in shared mode (readers), between 0 and 1")
+	budget    = flag.Int("budget", 0, "Total number of requests to the CS by all processes (0 for unlimited)")
+	duration  = flag.Duration("duration", 0, "For how long requests to the CS are issued (0 for unlimited)")
 )
 
+// onTerminate holds the functions to be run by the termination routine before the snapshots
+// are verified, or before exiting when the system is halted (e.g., to flush output files)
+var onTerminate = make([]func(), 0)
+
+// workersWg is used to wait for all workers to finish their workload
+var workersWg sync.WaitGroup
+
+// validator validates the fencing tokens of the accesses of all workers to the CS history (nil
+// unless -fence is set)
+var validator *fencing.Validator
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "viz" {
+		viz(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gencerts" {
+		gencerts(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
 	if len(flag.Args()) < 2 {
-		logrus.Errorf("Usage: %s [-v] [-f] <address:port> <address:port> [<address:port>...]", os.Args[0])
+		logrus.Errorf("Usage: %s [-v] [-log-json] [-log-level <levels>] [-f] [-s <seconds>] [-i <file>] [-k <snapshots>] [-bypass <requests>] [-o [-halt] [-dump]] [-dot <file>] [-t] [-metrics <address:port>] [-admin <address:port>] [-fd <mode> [-fd-interval <duration>] [-fd-timeout <duration>] [-fd-delta <duration>]] [-wal <dir>] [-lease <duration>] [-fence] [-priorities <classes>] [-aging <ticks>] [-auth-key <file>] [-tls <dir>] [-reliable] [workload flags] <address:port> <address:port> [<address:port>...]", os.Args[0])
+		os.Exit(1)
+	}
+
+	logCfg, err := loggingConfig()
+	if err != nil {
+		logrus.Errorf("%v", err)
 		os.Exit(1)
 	}
+	snapLogger := logCfg.Logger(logging.SNAPSHOTS)
 
-	loggingLevel := logrus.InfoLevel
-	if *verboseMode {
-		loggingLevel = logrus.DebugLevel
+	// load the invariant expressions upfront so that syntax errors are reported before running
+	verifierOpts, err := loadVerifierOpts(*exprsFile, *starvationK, *bypassN, *aging)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		os.Exit(1)
 	}
-	logrus.SetLevel(loggingLevel)
 
 	dimexOpts := make([]dimex.Opt, 0)
 	dimexOpts = append(dimexOpts, dimex.WithSnapshotIntervalOpt(*snapshotSec))
+	dimexOpts = append(
+		dimexOpts,
+		dimex.WithLoggerOpt(logCfg.Logger(logging.DIMEX)),
+		dimex.WithSnapshotLoggerOpt(snapLogger),
+		dimex.WithLinkLoggerOpt(logCfg.Logger(logging.PP2PLINK)),
+	)
 	if *failureMode {
 		logrus.Warnf(
 			"%sEnabling failure simulation in the DiMEx module. YOU WILL LIKELY SEE SNAPSHOT INVARIANTS VIOLATIONS!%s",
@@ -50,74 +141,442 @@ func main() {
 		)
 		dimexOpts = append(dimexOpts, dimex.WithFailOpt())
 	}
+	fdOpts, err := failureDetectorOpts()
+	if err != nil {
+		logrus.Errorf("%v", err)
+		os.Exit(1)
+	}
+	dimexOpts = append(dimexOpts, fdOpts...)
+	if *fenceMode {
+		validator = fencing.NewValidator()
+	}
+	if *lease > 0 {
+		logrus.Infof("Holding the CS under a lease of %v", *lease)
+		dimexOpts = append(dimexOpts, dimex.WithLeaseOpt(*lease))
+	}
+	if *aging <= 0 {
+		logrus.Errorf("Invalid aging %d: must be positive", *aging)
+		os.Exit(1)
+	}
+	dimexOpts = append(dimexOpts, dimex.WithAgingOpt(*aging))
+	if *authKeyFile != "" {
+		key, err := loadAuthKey(*authKeyFile)
+		if err != nil {
+			logrus.Errorf("%v", err)
+			os.Exit(1)
+		}
+		logrus.Infof("Authenticating the messages between processes with the key in '%s'", *authKeyFile)
+		dimexOpts = append(dimexOpts, dimex.WithAuthKeyOpt(key))
+	}
+	if *reliable {
+		logrus.Info("Delivering the messages between processes reliably, with acknowledgements and retransmissions")
+		dimexOpts = append(dimexOpts, dimex.WithReliableOpt())
+	}
 
 	addresses := flag.Args()
+
+	// the critical section history is verified at the end, so it must only have entries of this run
+	if err := os.WriteFile(HISTORY_FILE, nil, 0644); err != nil {
+		logrus.Errorf("Failed to truncate critical section history: %v", err)
+		os.Exit(1)
+	}
+
+	var collector *snapshots.Collector
+	if *onlineMode {
+		collectorOpts := []snapshots.CollectorOpt{snapshots.WithVerifierOpt(verifierOpts...), snapshots.WithLoggerOpt(snapLogger)}
+		if *haltMode {
+			collectorOpts = append(collectorOpts, snapshots.WithHaltOnViolationOpt())
+		}
+		if *dumpMode {
+			collectorOpts = append(collectorOpts, snapshots.WithDumpOnViolationOpt())
+		}
+		collector = snapshots.NewCollector(len(addresses), collectorOpts...)
+		dimexOpts = append(dimexOpts, dimex.WithCollectorOpt(collector))
+	}
+
+	workloadCfg, err := workloadConfig()
+	if err != nil {
+		logrus.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	var recorder *trace.Recorder
+	if *traceMode {
+		recorder, err = trace.NewRecorder("trace-shiviz.txt", "trace-shiviz-regex.txt", "trace-chrome.json")
+		if err != nil {
+			logrus.Errorf("Failed to create trace recorder: %v", err)
+			os.Exit(1)
+		}
+		onTerminate = append(onTerminate, func() {
+			if err := recorder.Close(); err != nil {
+				logrus.Errorf("Failed to write trace: %v", err)
+				return
+			}
+			logrus.Infof("Trace written to 'trace-shiviz.txt' (ShiViz, parsed with the regular expression in 'trace-shiviz-regex.txt') and 'trace-chrome.json' (Chrome trace)")
+		})
+		if *adminAddr != "" {
+			// the processes that join the group are not traced: their messages carry no vector
+			// timestamps, and the traced processes could not tell them apart from their content
+			logrus.Warnf("Joining the group through the admin API is disabled when tracing (-t)")
+		}
+	}
+
 	logrus.Infof(
 		"Starting DiMEx simulation with %d processes (with snapshots taken every %f seconds)...",
 		len(addresses),
 		*snapshotSec,
 	)
 	for i := range addresses {
+		nodeOpts := dimexOpts
+		if recorder != nil {
+			nodeOpts = append(nodeOpts[:len(nodeOpts):len(nodeOpts)], dimex.WithTracerOpt(recorder.Tracer(i, len(addresses))))
+		}
+		if *metricsAddr != "" {
+			registry, err := serveMetrics(*metricsAddr, i)
+			if err != nil {
+				logrus.Errorf("%v", err)
+				os.Exit(1)
+			}
+			nodeOpts = append(nodeOpts[:len(nodeOpts):len(nodeOpts)], dimex.WithMetricsOpt(registry))
+		}
+		if *tlsDir != "" {
+			tlsCfg, err := pp2plink.LoadTLSConfig(*tlsDir, addresses[i])
+			if err != nil {
+				logrus.Errorf("%v", err)
+				os.Exit(1)
+			}
+			nodeOpts = append(nodeOpts[:len(nodeOpts):len(nodeOpts)], dimex.WithTLSOpt(tlsCfg))
+		}
+		if *walDir != "" {
+			walLog, err := openWAL(*walDir, i)
+			if err != nil {
+				logrus.Errorf("%v", err)
+				os.Exit(1)
+			}
+			nodeOpts = append(nodeOpts[:len(nodeOpts):len(nodeOpts)], dimex.WithWALOpt(walLog))
+		}
 		dmx := dimex.NewDimex(
 			addresses,
 			i,
-			dimexOpts...,
+			nodeOpts...,
 		)
-		go worker(dmx)
+		nodeCfg := workloadCfg
+		if *adminAddr != "" {
+			nodeCfg.Gate = workload.NewGate()
+			var adminOpts []admin.Opt
+			if !*traceMode { // see the warning below
+				adminOpts = append(adminOpts, admin.WithJoinOpt(joiner(addresses[i], dimexOpts, workloadCfg)))
+			}
+			if err := serveAdmin(*adminAddr, dmx, i, nodeCfg.Gate, adminOpts...); err != nil {
+				logrus.Errorf("%v", err)
+				os.Exit(1)
+			}
+		}
+		workersWg.Add(1)
+		go worker(dmx, i, nodeCfg)
 	}
 
-	terminate()
+	// terminate when all workers are done, if the workload is finite
+	workersDone := make(chan struct{})
+	go func() {
+		workersWg.Wait()
+		close(workersDone)
+	}()
+
+	terminate(collector, workersDone, verifierOpts...)
 }
 
-// worker simulates the flow of an application that uses the DIMEX module
-// this code was provided as part of the skeleton implementation of the DIMEX module
-// and was slightly modified to work with the new implementation
-func worker(dmx *dimex.Dimex) {
-	// open file that all processes should write to
-	file, err := os.OpenFile("./mxOUT.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// loggingConfig builds the configuration of the loggers of the subsystems from the flags, and
+// configures the standard logger (used by the application itself) accordingly
+func loggingConfig() (logging.Config, error) {
+	levels, err := logging.ParseLevels(*logLevels)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return
+		return logging.Config{}, fmt.Errorf("invalid log levels: %w", err)
+	}
+	if _, ok := levels[logging.SNAPSHOTS]; !ok && *verboseMode {
+		levels[logging.SNAPSHOTS] = logrus.DebugLevel
 	}
-	defer file.Close()
 
-	// wait for a few seconds so all processes can be initialized
-	time.Sleep(2 * time.Second)
+	cfg := logging.Config{JSON: *logJSON, Level: logrus.InfoLevel, Levels: levels}
+	logrus.SetFormatter(cfg.Formatter())
+	logrus.SetLevel(cfg.Level)
 
-	// do application-specific work to simulate the use of the DIMEX module
-	for {
-		// asks to access the DIMEX
-		dmx.Req <- dimex.ENTER
+	return cfg, nil
+}
 
-		// waits for the DIMEX to be released by other processes
-		<-dmx.Ind
+// failureDetectorOpts builds the options to enable the failure detector, as configured by the
+// -fd flags (none if -fd is not set)
+func failureDetectorOpts() ([]dimex.Opt, error) {
+	if *fdMode == "" {
+		return nil, nil
+	}
+	mode, err := failuredetector.ParseMode(*fdMode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid failure detector: %w", err)
+	}
+	if *fdInterval <= 0 || *fdTimeout <= 0 || *fdDelta < 0 {
+		return nil, fmt.Errorf("invalid failure detector: interval and timeout must be positive, and delta not negative")
+	}
 
-		// write entry to file
-		if _, err := file.WriteString("|"); err != nil {
-			fmt.Println("Error writing to file:", err)
-			return
+	cfg := failuredetector.Config{Mode: mode, Interval: *fdInterval, Timeout: *fdTimeout, Delta: *fdDelta}
+	logrus.Infof("Enabling %s failure detector (heartbeats every %v, timeout %v)", mode, cfg.Interval, cfg.Timeout)
+	return []dimex.Opt{dimex.WithFailureDetectorOpt(cfg)}, nil
+}
+
+// openWAL opens the write-ahead log of the process with the given PID, in the given directory,
+// and closes it when the program terminates
+func openWAL(dir string, pid int) (*wal.Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create write-ahead log directory: %w", err)
+	}
+	walLog, err := wal.Open(filepath.Join(dir, fmt.Sprintf("wal-pid-%d.log", pid)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	if walLog.Recovered() != nil {
+		logrus.Infof("Process %d recovering from its write-ahead log", pid)
+	}
+	onTerminate = append(onTerminate, func() {
+		if err := walLog.Close(); err != nil {
+			logrus.Errorf("Failed to close write-ahead log: %v", err)
 		}
+	})
+	return walLog, nil
+}
 
-		// write exit to file
-		if _, err := file.WriteString("."); err != nil {
-			fmt.Println("Error writing to file:", err)
-			return
+// loadAuthKey reads the key shared by all processes to authenticate their messages from a file,
+// ignoring surrounding whitespace (e.g., a trailing newline)
+func loadAuthKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authentication key: %w", err)
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("authentication key in '%s' is empty", path)
+	}
+	return key, nil
+}
+
+// loadVerifierOpts builds the options to verify the snapshots with the invariant expressions
+// in exprsFile (if any), the starvation bound k and the bypass bound n (if greater than 0), with
+// requests ordered with the given aging of the priority classes
+func loadVerifierOpts(exprsFile string, k int, n int, aging int) ([]snapshots.VerifierOpt, error) {
+	verifierOpts := make([]snapshots.VerifierOpt, 0)
+	if exprsFile != "" {
+		exprs, err := snapshots.LoadExprInvariants(exprsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load invariant expressions: %w", err)
 		}
+		logrus.Infof("Loaded %d invariant expressions from '%s'", len(exprs), exprsFile)
+		verifierOpts = append(verifierOpts, snapshots.WithExprInvariantsOpt(exprs...))
+	}
 
-		// release the DIMEX module
-		dmx.Req <- dimex.EXIT
+	if k > 0 {
+		verifierOpts = append(verifierOpts, snapshots.WithStarvationBoundOpt(k))
 	}
+	if n > 0 {
+		verifierOpts = append(verifierOpts, snapshots.WithBypassBoundOpt(n, aging))
+	}
+
+	return verifierOpts, nil
 }
 
-func terminate() {
+// serveMetrics creates the metrics registry of the process with the given PID and serves it on
+// the /metrics endpoint at the port of baseAddr plus the PID
+func serveMetrics(baseAddr string, pid int) (*metrics.Registry, error) {
+	address, err := nodeAddress(baseAddr, pid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics address: %w", err)
+	}
+
+	registry := metrics.NewRegistry(pid)
+	if err := registry.Serve(address); err != nil {
+		return nil, fmt.Errorf("failed to serve metrics of P%d: %w", pid, err)
+	}
+	logrus.Infof("P%d: serving metrics on http://%s/metrics", pid, address)
+
+	return registry, nil
+}
+
+// serveAdmin serves the HTTP admin API of the process with the given PID at the port of baseAddr
+// plus the PID, pausing and resuming its workload with the given gate
+func serveAdmin(baseAddr string, dmx *dimex.Dimex, pid int, gate *workload.Gate, opts ...admin.Opt) error {
+	address, err := nodeAddress(baseAddr, pid)
+	if err != nil {
+		return fmt.Errorf("invalid admin address: %w", err)
+	}
+
+	if err := admin.NewServer(dmx, gate, opts...).Serve(address); err != nil {
+		return fmt.Errorf("failed to serve admin API of P%d: %w", pid, err)
+	}
+	logrus.Infof("P%d: serving admin API on http://%s", pid, address)
+
+	return nil
+}
+
+// joiner returns the function that starts a new process, listening on the given address, that joins
+// the group through the process at the seed address and runs the workload like the initial
+// processes. Processes that join the group are neither traced nor recovered from a write-ahead log,
+// and do not export metrics.
+func joiner(seed string, dimexOpts []dimex.Opt, workloadCfg workload.Config) func(address string) (int, error) {
+	return func(address string) (int, error) {
+		nodeOpts := dimexOpts
+		if *tlsDir != "" {
+			tlsCfg, err := pp2plink.LoadTLSConfig(*tlsDir, address)
+			if err != nil {
+				return -1, err
+			}
+			nodeOpts = append(nodeOpts[:len(nodeOpts):len(nodeOpts)], dimex.WithTLSOpt(tlsCfg))
+		}
+		dmx, err := dimex.JoinDimex(seed, address, nodeOpts...)
+		if err != nil {
+			return -1, fmt.Errorf("failed to join the group: %w", err)
+		}
+		pid := dmx.Inspect().PID
+		logrus.Infof("P%d: joined the group listening on %s", pid, address)
+
+		nodeCfg := workloadCfg
+		nodeCfg.Gate = workload.NewGate()
+		join := admin.WithJoinOpt(joiner(address, dimexOpts, workloadCfg))
+		if err := serveAdmin(*adminAddr, dmx, pid, nodeCfg.Gate, join); err != nil {
+			logrus.Errorf("%v", err)
+		}
+		workersWg.Add(1)
+		go worker(dmx, pid, nodeCfg)
+		return pid, nil
+	}
+}
+
+// nodeAddress returns the address of a per-process endpoint of the process with the given PID,
+// which listens on the port of baseAddr plus the PID
+func nodeAddress(baseAddr string, pid int) (string, error) {
+	host, portSpec, err := net.SplitHostPort(baseAddr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address '%s': %w", baseAddr, err)
+	}
+	port, err := strconv.Atoi(portSpec)
+	if err != nil {
+		return "", fmt.Errorf("invalid port '%s': %w", portSpec, err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port+pid)), nil
+}
+
+// workloadConfig builds the configuration of the workload of the processes from the flags
+func workloadConfig() (workload.Config, error) {
+	cfg := workload.DefaultConfig()
+	cfg.Warmup = *warmup
+	cfg.BurstSize = *burstSize
+	cfg.Duration = *duration
+	if *readRatio < 0 || *readRatio > 1 {
+		return cfg, fmt.Errorf("invalid read ratio %v: must be between 0 and 1", *readRatio)
+	}
+	cfg.ReadRatio = *readRatio
+
+	var err error
+	if cfg.Think, err = workload.ParseDistribution(*think); err != nil {
+		return cfg, fmt.Errorf("invalid think time: %w", err)
+	}
+	if cfg.Hold, err = workload.ParseDistribution(*hold); err != nil {
+		return cfg, fmt.Errorf("invalid hold time: %w", err)
+	}
+	if cfg.Pattern, err = workload.ParsePattern(*pattern); err != nil {
+		return cfg, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	if *rates != "" {
+		for _, rate := range strings.Split(*rates, ",") {
+			r, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+			if err != nil || r <= 0 {
+				return cfg, fmt.Errorf("invalid request rate '%s'", rate)
+			}
+			cfg.Rates = append(cfg.Rates, r)
+		}
+	}
+
+	if *priorities != "" {
+		for _, priority := range strings.Split(*priorities, ",") {
+			p, err := strconv.Atoi(strings.TrimSpace(priority))
+			if err != nil || p < 0 {
+				return cfg, fmt.Errorf("invalid priority class '%s'", priority)
+			}
+			cfg.Priorities = append(cfg.Priorities, p)
+		}
+	}
+
+	if *budget > 0 {
+		cfg.Budget = workload.NewBudget(*budget)
+	}
+
+	return cfg, nil
+}
+
+// worker simulates the flow of an application that uses the DIMEX module, running the
+// configured workload and writing its accesses to the CS to the shared file
+func worker(dmx *dimex.Dimex, pid int, cfg workload.Config) {
+	defer workersWg.Done()
+
+	// open file that all processes should write to
+	var histOpts []history.WriterOpt
+	if validator != nil {
+		histOpts = append(histOpts, history.WithValidatorOpt(validator))
+	}
+	hist, err := history.NewWriter(HISTORY_FILE, pid, histOpts...)
+	if err != nil {
+		logrus.WithError(err).Errorf("P%d: failed to open the CS history", pid)
+		return
+	}
+	defer hist.Close()
+
+	stats, err := workload.Run(dmx, pid, cfg, hist)
+	if err != nil {
+		logrus.WithError(err).Errorf("P%d: failed to run the workload", pid)
+		return
+	}
+	logrus.Infof("P%d: workload done after %d entries in the CS in %v", pid, stats.Entries, stats.Elapsed)
+	if stats.Expired > 0 {
+		logrus.Warnf("P%d: the lease of %d entries in the CS expired before they were exited", pid, stats.Expired)
+	}
+	if stats.Fenced > 0 {
+		logrus.Warnf("P%d: %d entries in the CS were fenced off from the CS history", pid, stats.Fenced)
+	}
+}
+
+// runOnTerminate runs the functions registered in onTerminate
+func runOnTerminate() {
+	for _, fn := range onTerminate {
+		fn()
+	}
+}
+
+func terminate(collector *snapshots.Collector, workersDone <-chan struct{}, verifierOpts ...snapshots.VerifierOpt) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	sig := <-sigChan // blocks until one of the signals above is received
+	var halted <-chan struct{} // nil (blocks forever) unless verifying online
+	if collector != nil {
+		halted = collector.Halted()
+	}
+
+	select { // blocks until one of the signals above is received, the workload is over or the system is halted
+	case sig := <-sigChan:
+		logrus.Infof("Received '%s' signal. Executing termination routine...", sig)
+	case <-workersDone:
+		logrus.Infof("Workload is over. Executing termination routine...")
+	case <-halted:
+		logrus.Infof("%sHalting on inconsistency detected in snapshots: %v%s", BOLD_RED, collector.Violation(), RESET)
+		explain(collector.Violation())
+		runOnTerminate() // e.g., the trace of the run up to the violation
+		os.Exit(1)
+	}
 
-	logrus.Infof("Received '%s' signal. Executing termination routine...", sig)
+	runOnTerminate()
 
-	snapsParser := snapshots.NewParser()
+	if collector != nil && collector.Violation() != nil {
+		logrus.Infof("%sInconsistency detected online in snapshots: %v%s", BOLD_RED, collector.Violation(), RESET)
+	}
+
+	snapsParser := snapshots.NewParser(verifierOpts...)
 	if err := snapsParser.Init(); err != nil {
 		logrus.Errorf("Failed to Init parser: %v", err)
 		os.Exit(1)
@@ -134,9 +593,45 @@ func terminate() {
 		)
 	}
 
+	consistent := true
 	if err := snapsParser.ParseVerify(); err != nil {
 		logrus.Infof("%sInconsistency detected in snapshots: %v%s", BOLD_RED, err, RESET)
+		explain(err)
+		consistent = false
+	} else {
+		logrus.Infof("%sNo inconsistencies detected in snapshots!%s", BOLD_GREEN, RESET)
+	}
+
+	logrus.Infof("Verifying critical section history...")
+
+	if err := history.Verify(HISTORY_FILE); err != nil {
+		logrus.Infof("%sInconsistency detected in critical section history: %v%s", BOLD_RED, err, RESET)
+		consistent = false
+	} else {
+		logrus.Infof("%sNo inconsistencies detected in critical section history!%s", BOLD_GREEN, RESET)
+	}
+
+	if !consistent {
 		os.Exit(1)
 	}
-	logrus.Infof("%sNo inconsistencies detected in snapshots!%s", BOLD_GREEN, RESET)
+}
+
+// explain prints a human-readable explanation of the global snapshot that violates an invariant
+// and, if requested, writes a DOT (Graphviz) rendering of it to a file
+func explain(err error) {
+	var violation *snapshots.ViolationError
+	if !errors.As(err, &violation) {
+		return
+	}
+
+	fmt.Print(violation.Explain())
+
+	if *dotFile == "" {
+		return
+	}
+	if err := os.WriteFile(*dotFile, []byte(violation.DOT()), 0644); err != nil {
+		logrus.Errorf("Failed to write DOT rendering of the violation: %v", err)
+		return
+	}
+	logrus.Infof("DOT rendering of the violation written to '%s'", *dotFile)
 }
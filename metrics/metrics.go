@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default upper bounds of the buckets of a histogram, in seconds, suited
+// for latencies from tens of microseconds to a few seconds.
+var DefaultBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// metric is a metric that can be written in the Prometheus text exposition format.
+type metric interface {
+	write(w io.Writer, constLabels string)
+}
+
+// Registry holds the metrics of a process, and writes them in the Prometheus text exposition
+// format (see https://prometheus.io/docs/instrumenting/exposition_formats/). All metrics of a
+// registry are labeled with the PID of the process.
+//
+// A nil *Registry is valid: it creates nil metrics, whose methods do nothing, so instrumented
+// code does not need to check whether metrics are enabled.
+type Registry struct {
+	mu          sync.Mutex
+	constLabels string
+	metrics     []metric
+}
+
+// NewRegistry creates a new instance of a Registry for the process with the given PID.
+func NewRegistry(pid int) *Registry {
+	return &Registry{constLabels: fmt.Sprintf(`pid="%d"`, pid)}
+}
+
+// Counter registers and returns a new counter with the given name and help text.
+func (r *Registry) Counter(name, help string) *Counter {
+	if r == nil {
+		return nil
+	}
+	c := &Counter{vec: newVec(name, help, "")}
+	r.register(c)
+	return c
+}
+
+// CounterVec registers and returns a new counter with the given name and help text,
+// partitioned by the value of the given label.
+func (r *Registry) CounterVec(name, help, label string) *CounterVec {
+	if r == nil {
+		return nil
+	}
+	c := &CounterVec{vec: newVec(name, help, label)}
+	r.register(c)
+	return c
+}
+
+// Histogram registers and returns a new histogram with the given name, help text and upper
+// bounds of the buckets (in increasing order).
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	if r == nil {
+		return nil
+	}
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	r.register(h)
+	return h
+}
+
+// WriteTo writes all metrics of the registry in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	for _, m := range r.metrics {
+		m.write(cw, r.constLabels)
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, fmt.Errorf("registry.WriteTo: failed writing metrics: %w", err)
+	}
+	return cw.n, nil
+}
+
+// ServeHTTP serves the metrics of the registry in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// Serve serves the metrics of the registry on the /metrics endpoint of an HTTP server listening
+// on the given address, in the background. It returns an error if the address cannot be listened on.
+func (r *Registry) Serve(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("registry.Serve: failed listening on '%s': %w", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	go http.Serve(listener, mux)
+
+	return nil
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	vec
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by the given (non-negative) value.
+func (c *Counter) Add(v float64) {
+	if c == nil {
+		return
+	}
+	c.add("", v)
+}
+
+// CounterVec is a set of monotonically increasing values, partitioned by the value of a label.
+type CounterVec struct {
+	vec
+}
+
+// Inc increments the counter with the given label value by 1.
+func (c *CounterVec) Inc(labelValue string) {
+	c.Add(labelValue, 1)
+}
+
+// Add increments the counter with the given label value by the given (non-negative) value.
+func (c *CounterVec) Add(labelValue string, v float64) {
+	if c == nil {
+		return
+	}
+	c.add(labelValue, v)
+}
+
+// vec holds the values of a counter, by label value.
+type vec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	label  string
+	values map[string]float64
+}
+
+func newVec(name, help, label string) vec {
+	return vec{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+func (v *vec) add(labelValue string, delta float64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[labelValue] += delta
+}
+
+func (v *vec) write(w io.Writer, constLabels string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", v.name, v.help, v.name)
+	if v.label == "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", v.name, constLabels, formatFloat(v.values[""]))
+		return
+	}
+
+	labelValues := make([]string, 0, len(v.values))
+	for labelValue := range v.values {
+		labelValues = append(labelValues, labelValue)
+	}
+	sort.Strings(labelValues)
+	for _, labelValue := range labelValues {
+		fmt.Fprintf(w, "%s{%s,%s=%q} %s\n", v.name, constLabels, v.label, labelValue, formatFloat(v.values[labelValue]))
+	}
+}
+
+// Histogram counts observations (e.g., latencies) in buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64 // upper bounds of the buckets
+	counts  []uint64  // number of observations in each bucket (not cumulative)
+	count   uint64
+	sum     float64
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count++
+	h.sum += v
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+}
+
+func (h *Histogram) write(w io.Writer, constLabels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.name, constLabels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, constLabels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, constLabels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, constLabels, h.count)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strings.TrimSuffix(strconv.FormatFloat(v, 'g', -1, 64), ".0")
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
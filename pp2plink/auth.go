@@ -0,0 +1,150 @@
+package pp2plink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// authSep separates the authentication tag of a frame from its content
+	authSep = "#"
+	// replayWindow is how far below the highest sequence number accepted from a sender a frame is
+	// still accepted, once: the frames of a failed connection may be read after the first ones of
+	// the next connection
+	replayWindow = 1024
+)
+
+var (
+	// errMalformedFrame is the error of a frame without a well-formed authentication tag
+	errMalformedFrame = errors.New("malformed frame")
+	// errBadMAC is the error of a frame whose MAC does not match its content
+	errBadMAC = errors.New("bad MAC")
+	// errReplay is the error of a frame with a sequence number already seen from its sender, or too
+	// old to tell
+	errReplay = errors.New("replayed frame")
+)
+
+// ------------------------------------------------------------------------------------
+// ------- autenticacao das mensagens com HMAC (chave compartilhada)
+// ------- cada quadro leva: remetente, numero de sequencia e HMAC-SHA256 do conteudo
+// ------------------------------------------------------------------------------------
+
+// WithHMACOpt is an option to authenticate every frame with an HMAC-SHA256 of the given key,
+// shared by all processes. Each frame carries the address of its sender, a sequence number of the
+// frames of the sender to its destination and the MAC of both, of the address of its destination
+// and of its content, so a frame can neither be forged without the key nor delivered to another
+// process. The receiver rejects the frames whose MAC does not match and the frames whose sequence
+// number was already accepted from their sender (replays). It keeps, for each sender, the highest
+// sequence number accepted and which of the replayWindow ones below it were accepted, so frames
+// read out of order (e.g., the last ones of a failed connection, read after the first ones of the
+// next connection) are still accepted; frames older than the window are rejected. Sequence numbers
+// start from the time the link starts, so they keep increasing when a process restarts. Rejected
+// frames are never delivered to the upper layer.
+func WithHMACOpt(key []byte) Opt {
+	return func(m *PP2PLink) {
+		m.authKey = key
+		m.authSeqs = make(map[string]uint64)
+		m.seqWindows = make(map[string]*seqWindow)
+	}
+}
+
+// seqWindow is the sliding window of the sequence numbers accepted from a sender.
+type seqWindow struct {
+	top  uint64                    // maior numero de sequencia aceito (0: nenhum)
+	seen [replayWindow / 64]uint64 // bitmap dos numeros aceitos em (top-replayWindow, top], indexado por seq % replayWindow
+}
+
+// accept records the given sequence number as accepted. It returns false if it was accepted before
+// or is below the window.
+func (w *seqWindow) accept(seq uint64) bool {
+	switch {
+	case seq > w.top: // desliza a janela, esquecendo os numeros que saem dela
+		if seq-w.top >= replayWindow {
+			w.seen = [replayWindow / 64]uint64{}
+		} else {
+			for s := w.top + 1; s <= seq; s++ {
+				w.seen[s%replayWindow/64] &^= 1 << (s % 64)
+			}
+		}
+		w.top = seq
+	case w.top-seq >= replayWindow: // antigo demais para saber se ja foi aceito
+		return false
+	case w.seen[seq%replayWindow/64]&(1<<(seq%64)) != 0:
+		return false
+	}
+	w.seen[seq%replayWindow/64] |= 1 << (seq % 64)
+	return true
+}
+
+// sign returns the frame with the given content to the given address, with its authentication
+// tag: <sender>,<seq>,<mac>#<content>
+func (m *PP2PLink) sign(to string, content string) string {
+	m.authSeqsMu.Lock()
+	seq, ok := m.authSeqs[to]
+	if !ok { // primeiro quadro para o destino nesta execucao
+		seq = uint64(time.Now().UnixNano())
+	}
+	seq++
+	m.authSeqs[to] = seq
+	m.authSeqsMu.Unlock()
+	return fmt.Sprintf("%s,%d,%s%s%s", m.address, seq, m.mac(m.address, to, seq, content), authSep, content)
+}
+
+// verify checks the authentication tag of the given frame, received by this process, and returns
+// its content. It returns an error if the frame is malformed, its MAC does not match or it is a
+// replay; the frame is then counted as rejected, by reason.
+func (m *PP2PLink) verify(frame string) (string, error) {
+	content, err := m.checkFrame(frame)
+	if err != nil {
+		reason := "malformed"
+		if errors.Is(err, errBadMAC) {
+			reason = "mac"
+		} else if errors.Is(err, errReplay) {
+			reason = "replay"
+		}
+		m.framesRejected.Inc(reason)
+	}
+	return content, err
+}
+
+func (m *PP2PLink) checkFrame(frame string) (string, error) {
+	tag, content, ok := strings.Cut(frame, authSep)
+	parts := strings.Split(tag, ",")
+	if !ok || len(parts) != 3 {
+		return "", fmt.Errorf("pp2plink.verify: %w", errMalformedFrame)
+	}
+	from := parts[0]
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("pp2plink.verify: sequence number '%s': %w", parts[1], errMalformedFrame)
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(m.mac(from, m.address, seq, content))) {
+		return "", fmt.Errorf("pp2plink.verify: frame from '%s': %w", from, errBadMAC)
+	}
+
+	m.seqWindowsMu.Lock()
+	defer m.seqWindowsMu.Unlock()
+	w, ok := m.seqWindows[from]
+	if !ok {
+		w = &seqWindow{}
+		m.seqWindows[from] = w
+	}
+	if !w.accept(seq) {
+		return "", fmt.Errorf("pp2plink.verify: sequence number %d from '%s' already accepted, or too old (highest %d): %w", seq, from, w.top, errReplay)
+	}
+	return content, nil
+}
+
+// mac returns the MAC (in hexadecimal) of the frame with the given sender, destination, sequence
+// number and content.
+func (m *PP2PLink) mac(from string, to string, seq uint64, content string) string {
+	h := hmac.New(sha256.New, m.authKey)
+	fmt.Fprintf(h, "%s,%s,%d,%s", from, to, seq, content)
+	return hex.EncodeToString(h.Sum(nil))
+}
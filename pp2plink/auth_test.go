@@ -0,0 +1,74 @@
+package pp2plink
+
+import (
+	"errors"
+	"testing"
+)
+
+// authLink returns a link at the given address that authenticates its frames with the given key,
+// without listening: frames are signed and verified directly.
+func authLink(address string, key string) *PP2PLink {
+	m := &PP2PLink{address: address}
+	WithHMACOpt([]byte(key))(m)
+	return m
+}
+
+func TestHMACRejectsReplay(t *testing.T) {
+	sender, receiver := authLink("p0", "key"), authLink("p1", "key")
+
+	frame := sender.sign("p1", "hello")
+	if content, err := receiver.verify(frame); err != nil || content != "hello" {
+		t.Fatalf("verify = %q, %v, want %q", content, err, "hello")
+	}
+	if _, err := receiver.verify(frame); !errors.Is(err, errReplay) {
+		t.Errorf("verify of a replayed frame = %v, want %v", err, errReplay)
+	}
+}
+
+func TestHMACAcceptsOutOfOrderFrames(t *testing.T) {
+	sender, receiver := authLink("p0", "key"), authLink("p1", "key")
+
+	// the last frame of a failed connection is read after the first one of the next connection
+	late := sender.sign("p1", "late")
+	early := sender.sign("p1", "early")
+	for _, frame := range []string{early, late} {
+		if _, err := receiver.verify(frame); err != nil {
+			t.Fatalf("verify(%q): %v", frame, err)
+		}
+	}
+	if _, err := receiver.verify(late); !errors.Is(err, errReplay) {
+		t.Errorf("verify of a replayed frame below the highest one = %v, want %v", err, errReplay)
+	}
+
+	// frames older than the window are rejected
+	old := sender.sign("p1", "old")
+	for i := 0; i < replayWindow; i++ {
+		if _, err := receiver.verify(sender.sign("p1", "new")); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	}
+	if _, err := receiver.verify(old); !errors.Is(err, errReplay) {
+		t.Errorf("verify of a frame below the window = %v, want %v", err, errReplay)
+	}
+}
+
+func TestHMACRejectsForgedFrames(t *testing.T) {
+	sender, receiver := authLink("p0", "key"), authLink("p1", "key")
+
+	tests := []struct {
+		name  string
+		frame string
+		want  error
+	}{
+		{"other key", authLink("p0", "other").sign("p1", "hello"), errBadMAC},
+		{"other destination", sender.sign("p2", "hello"), errBadMAC},
+		{"no tag", "hello", errMalformedFrame},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := receiver.verify(tt.frame); !errors.Is(err, tt.want) {
+				t.Errorf("verify(%q) = %v, want %v", tt.frame, err, tt.want)
+			}
+		})
+	}
+}
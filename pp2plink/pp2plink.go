@@ -12,69 +12,156 @@
   e recebe com io.ReadFull o tamanho informado - Dotti
   * Semestre 2022/1 - melhorias eliminando retorno de erro aos canais superiores.
   se conexao fecha nao retorna nada.   melhorias em comentarios.   adicionado modo debug. - Dotti
+  * Modo debug substituido por logger estruturado (nivel debug), injetado via WithLoggerOpt.
 */
 
 package pp2plink
 
 import (
-	"fmt"
+	"crypto/tls"
 	"io"
 	"net"
+	"pucrs/sd/metrics"
+	"pucrs/sd/trace"
 	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
 )
 
+// traceSep separates the vector timestamp piggybacked on a message from its content
+const traceSep = "|"
+
 type ReqMsg struct {
-	To      string
-	Message string
+	To         string
+	Message    string
+	Unreliable bool // com entrega confiavel, enviada uma so vez (p.ex., heartbeats, repetidos de qualquer forma)
 }
 
 type IndMsg struct {
 	From    string
+	Peer    string // identidade do remetente, do seu certificado (vazia sem TLS, ver WithTLSOpt)
 	Message string
 }
 
+type Opt func(*PP2PLink)
+
 type PP2PLink struct {
-	Ind   chan IndMsg
-	Req   chan ReqMsg
-	Run   bool
-	dbg   bool
-	Cache map[string]net.Conn // cache de conexoes - reaproveita conexao com destino ao inves de abrir outra
+	Ind     chan IndMsg
+	Req     chan ReqMsg
+	Run     bool
+	log     *logrus.Entry       // logger estruturado (mensagens de debug no nivel debug)
+	Cache   map[string]net.Conn // cache de conexoes - reaproveita conexao com destino ao inves de abrir outra
+	cacheMu sync.Mutex          // protege a cache, consultada tambem por Connected (nunca mantido em E/S)
+	tracer  *trace.Tracer       // records send/receive events with vector timestamps (optional)
+	address string              // endereco em que este processo escuta (remetente dos quadros autenticados)
+	tlsCfg  *tls.Config         // configuracao das conexoes com TLS (nil: conexoes sem TLS)
+
+	writeMus map[string]*sync.Mutex // serializa as escritas para cada destino (protegido por cacheMu)
+
+	authKey        []byte                // chave compartilhada do HMAC dos quadros (nil: sem autenticacao)
+	authSeqs       map[string]uint64     // numero de sequencia do ultimo quadro autenticado enviado a cada destino
+	authSeqsMu     sync.Mutex            // protege authSeqs
+	seqWindows     map[string]*seqWindow // numeros de sequencia aceitos de cada remetente
+	seqWindowsMu   sync.Mutex            // protege seqWindows, atualizado pelas rotinas de cada conexao
+	framesRejected *metrics.CounterVec   // quadros que falharam na autenticacao, por motivo
+
+	reliable        bool                 // entrega confiavel: filas, confirmacoes e retransmissoes
+	epoch           uint64               // epoca dos numeros de sequencia desta execucao
+	outQueues       map[string]*outQueue // fila de saida de cada destinatario
+	outMu           sync.Mutex           // protege outQueues
+	inStates        map[string]*inState  // estado da entrega das mensagens de cada remetente
+	inMu            sync.Mutex           // protege inStates
+	retransmissions *metrics.Counter     // mensagens retransmitidas por falta de confirmacao
+	duplicates      *metrics.Counter     // mensagens recebidas de novo e descartadas
+	dropped         *metrics.Counter     // mensagens descartadas com a fila de saida cheia
+
+	reconnects    *metrics.Counter // conexoes reabertas apos falha de escrita (nil se metricas desabilitadas)
+	bytesSent     *metrics.Counter
+	bytesReceived *metrics.Counter
 }
 
-func NewPP2PLink(_address string, _dbg bool) *PP2PLink {
+// WithTracerOpt is an option to record the sending and receiving of messages with the given
+// tracer. The vector timestamp of the sender is piggybacked on every message, so all processes
+// must be traced.
+func WithTracerOpt(tracer *trace.Tracer) Opt {
+	return func(m *PP2PLink) {
+		m.tracer = tracer
+	}
+}
+
+// WithMetricsOpt is an option to count the connection reconnects, the bytes sent and received on
+// the wire, the frames rejected by the authentication (see WithHMACOpt) and the messages
+// retransmitted, received twice and dropped from full queues (see WithReliableOpt) in the given
+// registry.
+func WithMetricsOpt(registry *metrics.Registry) Opt {
+	return func(m *PP2PLink) {
+		m.reconnects = registry.Counter("pp2plink_reconnects_total", "Connections reopened after a failed write.")
+		m.bytesSent = registry.Counter("pp2plink_bytes_sent_total", "Bytes sent on the wire, including the size prefix.")
+		m.bytesReceived = registry.Counter("pp2plink_bytes_received_total", "Bytes received on the wire, including the size prefix.")
+		m.framesRejected = registry.CounterVec("pp2plink_frames_rejected_total", "Frames received that failed the authentication, by reason.", "reason")
+		m.retransmissions = registry.Counter("pp2plink_retransmissions_total", "Messages retransmitted for lack of acknowledgement.")
+		m.duplicates = registry.Counter("pp2plink_duplicates_total", "Messages received again and dropped.")
+		m.dropped = registry.Counter("pp2plink_messages_dropped_total", "Messages dropped from a full outbound queue.")
+	}
+}
+
+// WithLoggerOpt is an option to log with the given logger (e.g., with the PID of the process
+// as a field) instead of the standard logger.
+func WithLoggerOpt(logger *logrus.Entry) Opt {
+	return func(m *PP2PLink) {
+		m.log = logger
+	}
+}
+
+func NewPP2PLink(_address string, opts ...Opt) *PP2PLink {
 	p2p := &PP2PLink{
 		Req:   make(chan ReqMsg, 1),
 		Ind:   make(chan IndMsg, 1),
 		Run:   true,
-		dbg:   _dbg,
+		log:   logrus.NewEntry(logrus.StandardLogger()),
 		Cache: make(map[string]net.Conn)}
+	p2p.writeMus = make(map[string]*sync.Mutex)
+	for _, opt := range opts {
+		opt(p2p)
+	}
 	p2p.outDbg(" Init PP2PLink!")
 	p2p.Start(_address)
 	return p2p
 }
 
 func (m *PP2PLink) outDbg(s string) {
-	if m.dbg {
-		fmt.Println(". . . . . . . . . . . . . . . . . [ PP2PLink msg : " + s + " ]")
-	}
+	m.log.Debug(s)
 }
 
 func (m *PP2PLink) Start(address string) {
+	m.address = address
 
 	// PROCESSO PARA RECEBIMENTO DE MENSAGENS
 	go func() {
-		listen, _ := net.Listen("tcp4", address)
+		listen, err := m.listen(address)
+		if err != nil {
+			m.log.WithError(err).Errorf("erro : nao foi possivel escutar em %s", address)
+			return
+		}
 		for {
 			// aceita repetidamente tentativas novas de conexao
 			conn, err := listen.Accept()
 			m.outDbg("ok   : conexao aceita com outro processo.")
 			// para cada conexao lanca rotina de tratamento
 			go func() {
+				peer, hsErr := peerIdentity(conn) // identidade do certificado do outro processo (com TLS)
+				if hsErr != nil {
+					m.log.WithError(hsErr).WithField("from", conn.RemoteAddr().String()).Error("erro : conexao nao autenticada")
+					conn.Close()
+					return
+				}
 				// repetidamente recebe mensagens na conexao TCP (sem fechar)
 				// e passa para modulo de cima
 				for { //                              // enquanto conexao aberta
 					if err != nil {
-						fmt.Println(".", err)
+						m.log.WithError(err).Error("erro : conexao nao aceita")
 						break
 					}
 					bufTam := make([]byte, 4) //       // le tamanho da mensagem
@@ -87,14 +174,27 @@ func (m *PP2PLink) Start(address string) {
 					bufMsg := make([]byte, tam)        // declara buffer do tamanho exato
 					_, err = io.ReadFull(conn, bufMsg) // le do tamanho do buffer ou da erro
 					if err != nil {
-						fmt.Println("@", err)
+						m.log.WithError(err).WithField("from", conn.RemoteAddr().String()).Error("erro : mensagem incompleta")
 						break
 					}
+					m.bytesReceived.Add(float64(len(bufTam) + tam))
 					msg := IndMsg{
 						From:    conn.RemoteAddr().String(),
+						Peer:    peer,
 						Message: string(bufMsg)}
-					// ATE AQUI:  procedimentos para receber msg
-					m.Ind <- msg //               // repassa mensagem para modulo superior
+					if m.authKey != nil { // descarta quadros nao autenticados antes de qualquer registro
+						message, err := m.verify(msg.Message)
+						if err != nil {
+							m.log.WithError(err).WithField("from", msg.From).Warn("erro : quadro rejeitado")
+							continue
+						}
+						msg.Message = message
+					}
+					if m.reliable { // confirma, descarta duplicatas e entrega em ordem
+						m.receive(msg, m.deliver)
+						continue
+					}
+					m.deliver(msg)
 				}
 			}()
 		}
@@ -109,21 +209,96 @@ func (m *PP2PLink) Start(address string) {
 	}()
 }
 
+// deliver delivers a message received to the upper layer.
+func (m *PP2PLink) deliver(msg IndMsg) {
+	if m.tracer != nil { // separa o timestamp vetorial do remetente da mensagem
+		timestamp, message, _ := strings.Cut(msg.Message, traceSep)
+		msg.Message = message
+		if err := m.tracer.Receive("receive "+message+" from "+msg.From, timestamp); err != nil {
+			m.outDbg("erro : " + err.Error())
+		}
+	}
+	// ATE AQUI:  procedimentos para receber msg
+	m.Ind <- msg //               // repassa mensagem para modulo superior
+}
+
+// Connected tells whether there is an open connection to the given address, i.e., whether the
+// last message to it was sent successfully.
+func (m *PP2PLink) Connected(address string) bool {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	_, ok := m.Cache[address]
+	return ok
+}
+
 func (m *PP2PLink) Send(message ReqMsg) {
-	var conn net.Conn
-	var ok bool
-	var err error
+	if m.tracer != nil { // anexa o timestamp vetorial do remetente aa mensagem
+		message.Message = m.tracer.Send("send "+message.Message+" to "+message.To) + traceSep + message.Message
+	}
+	if m.reliable { // entregue pela rotina de envio do destinatario, ate ser confirmada
+		m.enqueue(message.To, message.Message, !message.Unreliable)
+		return
+	}
+	m.write(message.To, message.Message)
+}
+
+// write writes a frame with the given content to the given address, on the cached connection to
+// it (opening a new one if needed, and once more if the write fails). It tells whether the frame
+// was written. Writes to the same address are serialized by a lock of their own, so cacheMu is
+// never held while a connection is opened or written to.
+func (m *PP2PLink) write(to string, content string) bool {
+	message := ReqMsg{To: to, Message: content}
+
+	mu := m.writeLock(message.To)
+	mu.Lock()
+	defer mu.Unlock()
 
 	// ja existe uma conexao aberta para aquele destinatario?
-	if conn, ok = m.Cache[message.To]; ok {
-	} else { // se nao existe, abre e guarda na cache
-		conn, err = net.Dial("tcp", message.To)
+	m.cacheMu.Lock()
+	conn, ok := m.Cache[message.To]
+	m.cacheMu.Unlock()
+	if !ok { // se nao existe, abre e guarda na cache
+		var err error
+		conn, err = m.dial(message.To)
+		if err != nil {
+			m.log.WithError(err).WithField("to", message.To).Error("erro : conexao nao iniciada")
+			return false
+		}
 		m.outDbg("ok   : conexao iniciada com outro processo")
+		m.cache(message.To, conn)
+	}
+	payload := m.frame(message)
+	_, err := conn.Write(payload)
+	if err == nil {
+		m.bytesSent.Add(float64(len(payload)))
+	} else {
+		m.outDbg("erro : " + err.Error() + ". Conexao fechada. 1 tentativa de reabrir:")
+		m.reconnects.Inc()
+		conn.Close()
+		conn, err = m.dial(message.To)
 		if err != nil {
-			fmt.Println(err)
-			return
+			//fmt.Println(err)
+			m.outDbg("       " + err.Error())
+			m.cache(message.To, nil)
+			return false
+		} else {
+			m.outDbg("ok   : conexao iniciada com outro processo.")
+		}
+		m.cache(message.To, conn)
+		if _, err = conn.Write(payload); err == nil {
+			m.bytesSent.Add(float64(len(payload)))
 		}
-		m.Cache[message.To] = conn
+	}
+	return err == nil
+}
+
+// frame returns the frame of the given message, as written on the wire: its size in 4 digits and
+// its content, authenticated (if enabled) with the next sequence number. It must be called just
+// before writing the frame, with the lock of its destination held, so the frames reach each
+// destination in the order of their sequence numbers.
+func (m *PP2PLink) frame(message ReqMsg) []byte {
+	if m.authKey != nil { // autentica o quadro inteiro, inclusive o timestamp vetorial
+		message.Message = m.sign(message.To, message.Message)
 	}
 	// calcula tamanho da mensagem e monta string de 4 caracteres numericos com o tamanho.
 	// completa com 0s aa esquerda para fechar tamanho se necessario.
@@ -134,19 +309,28 @@ func (m *PP2PLink) Send(message ReqMsg) {
 	if !(len(str) == 4) {
 		m.outDbg("ERROR AT PPLINK MESSAGE SIZE CALCULATION - INVALID MESSAGES MAY BE IN TRANSIT")
 	}
-	payload := append([]byte(str), []byte(message.Message)...) // escreve 4 caracteres com tamanho da mensagem e a mensagem
-	_, err = conn.Write(payload)
-	if err != nil {
-		m.outDbg("erro : " + err.Error() + ". Conexao fechada. 1 tentativa de reabrir:")
-		conn, err = net.Dial("tcp", message.To)
-		if err != nil {
-			//fmt.Println(err)
-			m.outDbg("       " + err.Error())
-			return
-		} else {
-			m.outDbg("ok   : conexao iniciada com outro processo.")
-		}
-		m.Cache[message.To] = conn
-		conn.Write(payload)
+	return append([]byte(str), []byte(message.Message)...) // escreve 4 caracteres com tamanho da mensagem e a mensagem
+}
+
+// writeLock returns the lock that serializes the writes to the given address.
+func (m *PP2PLink) writeLock(to string) *sync.Mutex {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	mu, ok := m.writeMus[to]
+	if !ok {
+		mu = &sync.Mutex{}
+		m.writeMus[to] = mu
+	}
+	return mu
+}
+
+// cache caches the connection to the given address (or removes it from the cache, if nil).
+func (m *PP2PLink) cache(to string, conn net.Conn) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	if conn == nil {
+		delete(m.Cache, to)
+		return
 	}
+	m.Cache[to] = conn
 }
@@ -0,0 +1,280 @@
+package pp2plink
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// relSep separates the header of the reliable delivery from the content of a frame
+	relSep = "~"
+	// dataFrame, ackFrame and onceFrame are the kinds of frames of the reliable delivery: messages
+	// delivered exactly once and in order, their acknowledgements and messages sent only once
+	dataFrame = "D"
+	ackFrame  = "A"
+	onceFrame = "U"
+
+	// maxQueue is how many messages to a peer are kept until it acknowledges them: when the queue is
+	// full (e.g., the peer crashed), the oldest message is dropped, and lost: the peer skips it
+	maxQueue = 1024
+
+	// retransmitMin is the time without acknowledgement after which the frames not acknowledged by
+	// a peer are retransmitted; it doubles after each retransmission, up to retransmitMax
+	retransmitMin = 100 * time.Millisecond
+	retransmitMax = 3 * time.Second
+)
+
+// ------------------------------------------------------------------------------------
+// ------- entrega confiavel: fila de saida por destinatario, numeros de sequencia,
+// ------- confirmacoes (acks), retransmissao com backoff e descarte de duplicatas
+// ------------------------------------------------------------------------------------
+
+// WithReliableOpt is an option to deliver the messages at most once and in FIFO order, retransmitting
+// them across failed connections until they are acknowledged. The messages to each peer are queued
+// with sequence numbers and written by a routine of their own, so Send does not block; they stay in
+// the queue until the peer acknowledges them, and are retransmitted from the oldest one when no
+// acknowledgement arrives in time (with an exponential backoff) or a connection fails. The receiver
+// delivers the messages of each sender in the order of their sequence numbers, acknowledges them
+// cumulatively and drops duplicates. Each run of a process is a new epoch of its sequence numbers,
+// so the peers of a process that restarts accept its messages again.
+//
+// Messages can still be lost: the queue of each peer holds up to maxQueue messages, and when more
+// are not acknowledged (e.g., the peer crashed, or is partitioned for long), the oldest ones are
+// dropped, logged and counted, and the peer skips them. Delivery is exactly once only while fewer
+// than maxQueue messages to a peer wait for its acknowledgement. The messages sent with
+// ReqMsg.Unreliable are written only once, in the routine of their peer (e.g., heartbeats, which
+// are repeated anyway). All processes must use the option, since it changes the frames on the
+// wire.
+func WithReliableOpt() Opt {
+	return func(m *PP2PLink) {
+		m.reliable = true
+		m.epoch = uint64(time.Now().UnixNano())
+		m.outQueues = make(map[string]*outQueue)
+		m.inStates = make(map[string]*inState)
+	}
+}
+
+// relFrame is a message queued to a peer, with its sequence number
+type relFrame struct {
+	seq     uint64
+	content string
+}
+
+// outQueue is the queue of the messages to a peer that it has not acknowledged yet.
+type outQueue struct {
+	mu       sync.Mutex
+	pending  []relFrame    // mensagens ainda nao confirmadas, em ordem
+	once     []string      // mensagens a escrever uma so vez, sem confirmacao
+	written  int           // quantas de pending ja foram escritas desde a ultima retransmissao
+	next     uint64        // numero de sequencia da proxima mensagem
+	backoff  time.Duration // tempo sem confirmacao ate a proxima retransmissao
+	progress time.Time     // ultima confirmacao (ou retransmissao) das mensagens da fila
+	wake     chan struct{} // avisa a rotina de envio de novas mensagens
+}
+
+// inState is the state of the delivery of the messages from a peer.
+type inState struct {
+	mu       sync.Mutex // mantido durante a entrega: as mensagens saem em ordem mesmo entre conexoes
+	epoch    uint64     // epoca do remetente (0: nenhuma mensagem recebida)
+	expected uint64     // numero de sequencia da proxima mensagem a entregar
+}
+
+// enqueue queues a message to the given address, to be written by its routine: until the peer
+// acknowledges it if reliable, otherwise only once.
+func (m *PP2PLink) enqueue(to string, content string, reliable bool) {
+	m.outMu.Lock()
+	q, ok := m.outQueues[to]
+	if !ok {
+		q = &outQueue{next: 1, backoff: retransmitMin, wake: make(chan struct{}, 1)}
+		m.outQueues[to] = q
+		go m.sendLoop(to, q)
+	}
+	m.outMu.Unlock()
+
+	q.mu.Lock()
+	if !reliable {
+		if len(q.once) >= maxQueue {
+			q.once = q.once[1:]
+			m.dropped.Inc()
+		}
+		q.once = append(q.once, content)
+	} else {
+		if len(q.pending) == 0 {
+			q.progress = time.Now()
+		}
+		if len(q.pending) >= maxQueue { // o destinatario nao confirma ha muito tempo: descarta a mais antiga
+			m.log.WithField("to", to).Warn("fila de saida cheia: mensagem mais antiga descartada (perdida)")
+			q.pending = q.pending[1:]
+			if q.written > 0 {
+				q.written--
+			}
+			m.dropped.Inc()
+		}
+		q.pending = append(q.pending, relFrame{seq: q.next, content: content})
+		q.next++
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default: // a rotina de envio ja foi avisada
+	}
+}
+
+// sendLoop writes the messages queued to the given address as they are queued, and retransmits
+// the ones not acknowledged, from the oldest one, when the peer does not acknowledge any of them in
+// time or a write fails.
+func (m *PP2PLink) sendLoop(to string, q *outQueue) {
+	for {
+		q.mu.Lock()
+		var timeout <-chan time.Time // nil (bloqueia para sempre) com a fila vazia
+		if len(q.pending) > 0 {
+			timeout = time.After(time.Until(q.progress.Add(q.backoff)))
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.wake:
+		case <-timeout:
+		}
+
+		q.mu.Lock()
+		if len(q.pending) > 0 && time.Since(q.progress) >= q.backoff { // sem confirmacao a tempo
+			m.retransmissions.Add(float64(q.written))
+			q.written = 0
+			q.progress = time.Now()
+			q.backoff *= 2
+			if q.backoff > retransmitMax {
+				q.backoff = retransmitMax
+			}
+		}
+		once := q.once
+		q.once = nil
+		frames := append([]relFrame(nil), q.pending[q.written:]...)
+		base := uint64(0)
+		if len(q.pending) > 0 {
+			base = q.pending[0].seq
+		}
+		q.written = len(q.pending)
+		q.mu.Unlock()
+
+		for _, content := range once {
+			if !m.write(to, fmt.Sprintf("%s,%s%s%s", onceFrame, m.address, relSep, content)) {
+				break // perdidas, como as demais ainda nao escritas
+			}
+		}
+		for _, frame := range frames {
+			header := fmt.Sprintf("%s,%s,%d,%d,%d", dataFrame, m.address, m.epoch, base, frame.seq)
+			if !m.write(to, header+relSep+frame.content) {
+				break // retransmitidas desde a mais antiga quando o tempo sem confirmacao acabar
+			}
+		}
+	}
+}
+
+// receive handles a frame of the reliable delivery received from a peer: an acknowledgement of the
+// messages sent to it, a message sent only once, which is handed over to deliver, or a message,
+// which is handed over to deliver if it is the next one of its sender (and dropped if it is a
+// duplicate, or if a previous one is missing) and acknowledged.
+func (m *PP2PLink) receive(msg IndMsg, deliver func(IndMsg)) {
+	header, content, ok := strings.Cut(msg.Message, relSep)
+	parts := strings.Split(header, ",")
+	if !ok || len(parts) < 2 {
+		m.log.WithField("from", msg.From).Warn("erro : quadro sem cabecalho de entrega confiavel")
+		return
+	}
+	from := parts[1]
+	if msg.Peer != "" && msg.Peer != from { // com TLS, o remetente e o do certificado
+		m.log.WithField("from", msg.From).Warn("erro : remetente diferente do certificado")
+		return
+	}
+	switch {
+	case len(parts) == 2 && parts[0] == onceFrame:
+		msg.Message = content
+		deliver(msg)
+		return
+	case len(parts) == 4 && parts[0] == ackFrame:
+		epoch, err1 := strconv.ParseUint(parts[2], 10, 64)
+		seq, err2 := strconv.ParseUint(parts[3], 10, 64)
+		if err1 == nil && err2 == nil && epoch == m.epoch { // acks de uma execucao anterior sao ignorados
+			m.handleAck(from, seq)
+		}
+		return
+	case len(parts) != 5 || parts[0] != dataFrame:
+		m.log.WithField("from", msg.From).Warn("erro : quadro sem cabecalho de entrega confiavel")
+		return
+	}
+	epoch, err1 := strconv.ParseUint(parts[2], 10, 64)
+	base, err2 := strconv.ParseUint(parts[3], 10, 64)
+	seq, err3 := strconv.ParseUint(parts[4], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		m.log.WithField("from", msg.From).Warn("erro : cabecalho de entrega confiavel invalido")
+		return
+	}
+
+	st := m.inState(from)
+	st.mu.Lock()
+	if epoch < st.epoch { // de uma execucao anterior do remetente
+		st.mu.Unlock()
+		return
+	}
+	if epoch > st.epoch || base > st.expected {
+		// primeira mensagem do remetente (ou de uma nova execucao dele), ou o remetente descartou as
+		// mensagens anteriores a base (fila cheia): sao puladas
+		st.epoch = epoch
+		st.expected = base
+	}
+	if seq == st.expected {
+		msg.Message = content
+		deliver(msg)
+		st.expected++
+	} else if seq < st.expected {
+		m.duplicates.Inc()
+	}
+	acked := st.expected - 1
+	st.mu.Unlock()
+
+	m.write(from, fmt.Sprintf("%s,%s,%d,%d%s", ackFrame, m.address, epoch, acked, relSep))
+}
+
+// handleAck removes the messages acknowledged by the peer at the given address from its queue.
+func (m *PP2PLink) handleAck(from string, acked uint64) {
+	m.outMu.Lock()
+	q, ok := m.outQueues[from]
+	m.outMu.Unlock()
+	if !ok {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for n < len(q.pending) && q.pending[n].seq <= acked {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	q.pending = q.pending[n:]
+	q.written -= n
+	if q.written < 0 {
+		q.written = 0
+	}
+	q.backoff = retransmitMin
+	q.progress = time.Now()
+}
+
+// inState returns the state of the delivery of the messages from the peer at the given address.
+func (m *PP2PLink) inState(from string) *inState {
+	m.inMu.Lock()
+	defer m.inMu.Unlock()
+	st, ok := m.inStates[from]
+	if !ok {
+		st = &inState{}
+		m.inStates[from] = st
+	}
+	return st
+}
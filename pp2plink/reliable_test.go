@@ -0,0 +1,104 @@
+package pp2plink
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testTimeout bounds the wait for a message that is expected to arrive
+const testTimeout = 5 * time.Second
+
+// quietLogger returns a logger that discards its entries.
+func quietLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logrus.NewEntry(logger)
+}
+
+// freeAddress returns a local address that is free to listen on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("freeAddress: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// reliableLink starts a link with reliable delivery at the given address.
+func reliableLink(address string) *PP2PLink {
+	return NewPP2PLink(address, WithReliableOpt(), WithLoggerOpt(quietLogger()))
+}
+
+func TestReliableRetransmitsUntilPeerListens(t *testing.T) {
+	senderAddress, receiverAddress := freeAddress(t), freeAddress(t)
+	sender := reliableLink(senderAddress)
+
+	// the first writes fail: the peer is not listening yet
+	const n = 20
+	for i := 0; i < n; i++ {
+		sender.Send(ReqMsg{To: receiverAddress, Message: fmt.Sprint(i)})
+	}
+	time.Sleep(300 * time.Millisecond)
+	receiver := reliableLink(receiverAddress)
+
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-receiver.Ind:
+			if want := fmt.Sprint(i); msg.Message != want {
+				t.Fatalf("message %d = %q, want %q", i, msg.Message, want)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("message %d not delivered within %v", i, testTimeout)
+		}
+	}
+	select {
+	case msg := <-receiver.Ind:
+		t.Errorf("unexpected message %q after the %d sent", msg.Message, n)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestReliableDeliversDuplicateOnce(t *testing.T) {
+	receiver := reliableLink(freeAddress(t))
+	var delivered []string
+	deliver := func(msg IndMsg) { delivered = append(delivered, msg.Message) }
+
+	// a frame retransmitted after its acknowledgement was lost
+	frame := fmt.Sprintf("%s,p0,1,1,1%shello", dataFrame, relSep)
+	receiver.receive(IndMsg{From: "p0", Message: frame}, deliver)
+	receiver.receive(IndMsg{From: "p0", Message: frame}, deliver)
+	if len(delivered) != 1 || delivered[0] != "hello" {
+		t.Errorf("delivered = %q, want [hello]", delivered)
+	}
+
+	// a frame after a missing one is not delivered before it
+	next := fmt.Sprintf("%s,p0,1,1,3%sworld", dataFrame, relSep)
+	receiver.receive(IndMsg{From: "p0", Message: next}, deliver)
+	if len(delivered) != 1 {
+		t.Errorf("delivered = %q after a gap, want [hello]", delivered)
+	}
+}
+
+func TestReliableDropsOldestFromFullQueue(t *testing.T) {
+	sender := reliableLink(freeAddress(t))
+	to := freeAddress(t) // never listens: no message is acknowledged
+
+	for i := 0; i <= maxQueue; i++ {
+		sender.Send(ReqMsg{To: to, Message: fmt.Sprint(i)})
+	}
+	sender.outMu.Lock()
+	q := sender.outQueues[to]
+	sender.outMu.Unlock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) != maxQueue || q.pending[0].seq != 2 {
+		t.Errorf("queue of %d messages from seq %d, want %d from seq 2", len(q.pending), q.pending[0].seq, maxQueue)
+	}
+}
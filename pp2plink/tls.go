@@ -0,0 +1,117 @@
+package pp2plink
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CAFile is the name of the file with the certificate of the CA of the processes, in the directory
+// of the certificates (see LoadTLSConfig).
+const CAFile = "ca.pem"
+
+// dialTimeout is how long opening a connection (including the TLS handshake) may take, so a write
+// to a crashed host does not block forever
+const dialTimeout = 2 * time.Second
+
+// ------------------------------------------------------------------------------------
+// ------- conexoes com TLS e autenticacao mutua (mTLS)
+// ------- a identidade de cada processo e o seu endereco, no CommonName do certificado
+// ------------------------------------------------------------------------------------
+
+// WithTLSOpt is an option to run the connections over TLS with the given configuration, both to
+// accept connections and to open them. The configuration should require and verify the
+// certificates of the clients (mutual TLS, see LoadTLSConfig): the identity of a process is the
+// address it listens on, in the CommonName of its certificate. A connection opened to an address is
+// closed if the certificate of the process that accepted it is not for that address, and the
+// messages received on an accepted connection are delivered with the identity of the process that
+// opened it (IndMsg.Peer), so the upper layer can check the sender claimed in them.
+func WithTLSOpt(cfg *tls.Config) Opt {
+	return func(m *PP2PLink) {
+		m.tlsCfg = cfg
+	}
+}
+
+// LoadTLSConfig loads the mutual TLS configuration of the process listening on the given address
+// from the given directory: the certificate of the CA of the processes (CAFile) and the certificate
+// and key of the process (see CertFiles). Both the processes that accept connections and the ones
+// that open them must present a certificate signed by the CA.
+func LoadTLSConfig(dir string, address string) (*tls.Config, error) {
+	certFile, keyFile := CertFiles(dir, address)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("pp2plink.LoadTLSConfig: failed loading certificate of '%s': %w", address, err)
+	}
+	caPEM, err := os.ReadFile(filepath.Join(dir, CAFile))
+	if err != nil {
+		return nil, fmt.Errorf("pp2plink.LoadTLSConfig: failed reading CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("pp2plink.LoadTLSConfig: no certificate in '%s'", filepath.Join(dir, CAFile))
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// CertFiles returns the paths of the certificate and of the key of the process listening on the
+// given address, in the given directory: node-<host>_<port>.pem and node-<host>_<port>-key.pem.
+func CertFiles(dir string, address string) (certFile string, keyFile string) {
+	name := "node-" + strings.ReplaceAll(address, ":", "_")
+	return filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+}
+
+// listen listens for connections on the given address, over TLS if configured.
+func (m *PP2PLink) listen(address string) (net.Listener, error) {
+	if m.tlsCfg == nil {
+		return net.Listen("tcp4", address)
+	}
+	return tls.Listen("tcp4", address, m.tlsCfg)
+}
+
+// dial opens a connection to the given address, over TLS if configured, checking that the
+// certificate of the process that accepts it is for that address. It fails after dialTimeout.
+func (m *PP2PLink) dial(to string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if m.tlsCfg == nil {
+		return dialer.Dial("tcp", to)
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", to, m.tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	if peer, err := peerIdentity(conn); err != nil || peer != to {
+		conn.Close()
+		return nil, fmt.Errorf("pp2plink.dial: certificate of '%s' is for '%s': %v", to, peer, err)
+	}
+	return conn, nil
+}
+
+// peerIdentity returns the identity of the process at the other end of the given connection: the
+// CommonName of its certificate, completing the TLS handshake if needed. Without TLS, it returns an
+// empty identity.
+func peerIdentity(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return "", fmt.Errorf("pp2plink.peerIdentity: TLS handshake failed: %w", err)
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", errors.New("pp2plink.peerIdentity: no certificate presented")
+	}
+	return certs[0].Subject.CommonName, nil
+}
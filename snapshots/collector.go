@@ -0,0 +1,210 @@
+package snapshots
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Collector verifies the snapshots online, while the system is running. It receives each local
+// snapshot as soon as it is completed, assembles the global snapshot once every process has
+// reported its local snapshot with the same ID, and immediately checks it against the invariants.
+type Collector struct {
+	mu              sync.Mutex
+	nProcesses      int
+	verifier        *verifier
+	pending         map[int][]Snapshot // local snapshots received so far, by snapshot ID
+	lastVerified    int                // ID of the last global snapshot verified (-1: none)
+	haltOnViolation bool
+	dumpOnViolation bool
+	halted          chan struct{}
+	violation       error
+	log             *logrus.Entry
+}
+
+// CollectorOpt is an option to customize the Collector.
+type CollectorOpt func(*Collector)
+
+// WithVerifierOpt is an option to customize how the Collector verifies the snapshots.
+func WithVerifierOpt(opts ...VerifierOpt) CollectorOpt {
+	return func(c *Collector) {
+		for _, opt := range opts {
+			opt(c.verifier)
+		}
+	}
+}
+
+// WithHaltOnViolationOpt is an option to halt the system on the first invariant violation.
+// After the first violation, the channel returned by Halted() is closed and no more snapshots
+// are verified.
+func WithHaltOnViolationOpt() CollectorOpt {
+	return func(c *Collector) {
+		c.haltOnViolation = true
+	}
+}
+
+// WithDumpOnViolationOpt is an option to dump the global snapshot that violates an invariant
+// to a file (violation-snapid-<n>.txt), for debugging purposes.
+func WithDumpOnViolationOpt() CollectorOpt {
+	return func(c *Collector) {
+		c.dumpOnViolation = true
+	}
+}
+
+// WithLoggerOpt is an option to log the results of the verification with the given logger
+// instead of the standard logger.
+func WithLoggerOpt(logger *logrus.Entry) CollectorOpt {
+	return func(c *Collector) {
+		c.log = logger
+	}
+}
+
+// NewCollector creates a new instance of a Collector for a system with the given number of processes.
+func NewCollector(nProcesses int, opts ...CollectorOpt) *Collector {
+	c := &Collector{
+		nProcesses:   nProcesses,
+		verifier:     newVerifier(),
+		pending:      make(map[int][]Snapshot),
+		lastVerified: -1,
+		halted:       make(chan struct{}),
+		log:          logrus.NewEntry(logrus.StandardLogger()),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Submit hands a completed local snapshot over to the Collector. If it is the last local snapshot
+// missing for its global snapshot, the global snapshot is verified right away and the violation
+// found (if any) is returned. Submit is safe for concurrent use by multiple processes.
+func (c *Collector) Submit(s Snapshot) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.haltOnViolation && c.violation != nil {
+		// the system is halting; stop verifying
+		return nil
+	}
+
+	if s.ID <= c.lastVerified {
+		// a process that joined the group while the snapshot was in progress, and was not expected, or
+		// that was not expected in a later snapshot already verified: each process completes its
+		// snapshots in the order of their IDs, so the expected ones will never complete this one
+		return nil
+	}
+
+	c.pending[s.ID] = append(c.pending[s.ID], s)
+	if len(c.pending[s.ID]) < c.countExpected(c.pending[s.ID]) {
+		return nil
+	}
+
+	snapshots := c.pending[s.ID]
+	for id := range c.pending {
+		if id <= s.ID { // the snapshots before this one will never be completed
+			delete(c.pending, id)
+		}
+	}
+	c.lastVerified = s.ID
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].PID < snapshots[j].PID })
+
+	err := c.verifier.verify(snapshots)
+	if err == nil {
+		c.log.WithField("snapId", s.ID).Debug("collector.Submit: snapshot verified with no inconsistencies")
+		return nil
+	}
+
+	err = fmt.Errorf("collector.Submit (snapId %d): %w", s.ID, err)
+	c.log.WithField("snapId", s.ID).Error(err)
+
+	if c.dumpOnViolation {
+		if dumpErr := dumpViolation(s.ID, snapshots, err); dumpErr != nil {
+			c.log.WithField("snapId", s.ID).WithError(dumpErr).Error("collector.Submit: failed dumping violation")
+		}
+	}
+
+	if c.violation == nil {
+		c.violation = err
+		if c.haltOnViolation {
+			close(c.halted)
+		}
+	}
+
+	return err
+}
+
+// countExpected counts the processes whose local snapshots are expected in the global snapshot of
+// the given local snapshots: the members of the group in the views of all of them, except the
+// processes suspected by any of them. Suspected processes may have crashed, and processes joining
+// or leaving the group may not take part in the snapshot, so the global snapshot is assembled
+// without waiting for their local snapshots.
+func (c *Collector) countExpected(snapshots []Snapshot) int {
+	nProcesses := c.nProcesses
+	for _, s := range snapshots {
+		if len(s.Members) > nProcesses {
+			nProcesses = len(s.Members)
+		}
+	}
+
+	expected := 0
+	for pid := 0; pid < nProcesses; pid++ {
+		isExpected := true
+		for _, s := range snapshots {
+			if !s.IsMember(pid) || s.IsSuspected(pid) {
+				isExpected = false
+				break
+			}
+		}
+		if isExpected {
+			expected++
+		}
+	}
+	return expected
+}
+
+// Halted returns a channel that is closed when the Collector finds the first invariant violation,
+// if it has been created with the WithHaltOnViolationOpt option.
+func (c *Collector) Halted() <-chan struct{} {
+	return c.halted
+}
+
+// Violation returns the first invariant violation found by the Collector, or nil if there is none.
+func (c *Collector) Violation() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.violation
+}
+
+// dumpViolation writes the violation and the global snapshot that caused it to a file, one
+// local snapshot per line in JSON format.
+func dumpViolation(snapId int, snapshots []Snapshot, violation error) error {
+	path := fmt.Sprintf("violation-snapid-%d.txt", snapId)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("dumpViolation: failed creating '%s' file: %w", path, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(violation.Error() + "\n"); err != nil {
+		return fmt.Errorf("dumpViolation: failed writing to '%s' file: %w", path, err)
+	}
+
+	for i := range snapshots {
+		snapshotJson, err := json.Marshal(&snapshots[i])
+		if err != nil {
+			return fmt.Errorf("dumpViolation: failed marshaling snapshot to JSON: %w", err)
+		}
+		if _, err = file.WriteString(string(snapshotJson) + "\n"); err != nil {
+			return fmt.Errorf("dumpViolation: failed writing to '%s' file: %w", path, err)
+		}
+	}
+
+	return nil
+}
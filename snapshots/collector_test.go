@@ -0,0 +1,49 @@
+package snapshots
+
+import (
+	"io"
+	"pucrs/sd/common"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// idleSnapshot returns the local snapshot with the given ID of an idle process of a system with
+// the given number of processes.
+func idleSnapshot(id, pid, nProcesses int) Snapshot {
+	return Snapshot{ID: id, PID: pid, State: common.NoMX, Waiting: make([]bool, nProcesses)}
+}
+
+func TestCollectorPrunesVerifiedSnapshots(t *testing.T) {
+	quiet := logrus.New()
+	quiet.SetOutput(io.Discard)
+	c := NewCollector(2, WithLoggerOpt(logrus.NewEntry(quiet)))
+
+	// snapshot 0 is abandoned by P1, which completes snapshot 1 instead
+	if err := c.Submit(idleSnapshot(0, 0, 2)); err != nil {
+		t.Fatalf("Submit(0, P0): %v", err)
+	}
+	if err := c.Submit(idleSnapshot(1, 0, 2)); err != nil {
+		t.Fatalf("Submit(1, P0): %v", err)
+	}
+	if err := c.Submit(idleSnapshot(1, 1, 2)); err != nil {
+		t.Fatalf("Submit(1, P1): %v", err)
+	}
+	if len(c.pending) != 0 {
+		t.Errorf("pending snapshots after verifying snapshot 1 = %v, want none", c.pending)
+	}
+
+	// late snapshots at or below the last one verified are ignored, and not kept
+	if err := c.Submit(idleSnapshot(0, 1, 2)); err != nil {
+		t.Fatalf("Submit(0, P1): %v", err)
+	}
+	if err := c.Submit(idleSnapshot(1, 2, 3)); err != nil {
+		t.Fatalf("Submit(1, P2): %v", err)
+	}
+	if len(c.pending) != 0 {
+		t.Errorf("pending snapshots after late submissions = %v, want none", c.pending)
+	}
+	if c.lastVerified != 1 {
+		t.Errorf("lastVerified = %d, want 1", c.lastVerified)
+	}
+}
@@ -0,0 +1,177 @@
+package snapshots
+
+import (
+	"fmt"
+	"pucrs/sd/common"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ViolationError is the error returned when a global snapshot (set of snapshots with the same ID)
+// violates an invariant. Besides the violation itself, it carries the offending global snapshot
+// and the one before it, so that the violation can be explained.
+type ViolationError struct {
+	SnapID    int
+	Snapshots []Snapshot
+	Previous  []Snapshot // nil if the offending global snapshot is the first one
+	Err       error
+}
+
+// Error returns the description of the violation.
+func (v *ViolationError) Error() string {
+	return v.Err.Error()
+}
+
+// Unwrap returns the underlying violation.
+func (v *ViolationError) Unwrap() error {
+	return v.Err
+}
+
+// Explain returns a human-readable explanation of the violation: the state of each process in
+// the offending global snapshot, including its in-transit messages, and what changed since the
+// previous global snapshot.
+func (v *ViolationError) Explain() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Global snapshot %d violates an invariant: %v\n", v.SnapID, v.Err)
+	for _, snapshot := range sortedByPID(v.Snapshots) {
+		fmt.Fprintf(&b, "  P%d: %s\n", snapshot.PID, strings.Join(describeFields(snapshot), " "))
+		for _, pid := range sortedChanPIDs(snapshot) {
+			for _, msg := range snapshot.CommunicationChans[pid].Messages {
+				fmt.Fprintf(&b, "      in transit: P%d -> P%d: %s\n", pid, snapshot.PID, msg.Message)
+			}
+		}
+	}
+
+	if v.Previous == nil {
+		b.WriteString("No previous global snapshot to compare with.\n")
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "Changes since global snapshot %d:\n", v.Previous[0].ID)
+	for _, snapshot := range sortedByPID(v.Snapshots) {
+		prev, ok := findByPID(v.Previous, snapshot.PID)
+		if !ok {
+			fmt.Fprintf(&b, "  P%d: not in the previous global snapshot\n", snapshot.PID)
+			continue
+		}
+		changes := diffFields(prev, snapshot)
+		if len(changes) == 0 {
+			changes = []string{"no changes"}
+		}
+		fmt.Fprintf(&b, "  P%d: %s\n", snapshot.PID, strings.Join(changes, ", "))
+	}
+
+	return b.String()
+}
+
+// DOT returns a rendering of the offending global snapshot in the DOT (Graphviz) language. Each
+// process is a node coloured by its state, each in-transit message is a solid edge from its
+// sender to its receiver, and each delayed entry response is a dashed edge from the process
+// delaying it to the process waiting for it.
+func (v *ViolationError) DOT() string {
+	var b strings.Builder
+
+	b.WriteString("digraph snapshot {\n")
+	fmt.Fprintf(&b, "  label=%q;\n", fmt.Sprintf("Global snapshot %d: %v", v.SnapID, v.Err))
+	b.WriteString("  labelloc=t;\n")
+	b.WriteString("  node [shape=box, style=filled];\n")
+
+	for _, snapshot := range sortedByPID(v.Snapshots) {
+		label := fmt.Sprintf("P%d\n%s", snapshot.PID, strings.Join(describeFields(snapshot), "\n"))
+		fmt.Fprintf(&b, "  P%d [label=%q, fillcolor=%q];\n", snapshot.PID, label, stateColor(snapshot.State))
+	}
+
+	for _, snapshot := range sortedByPID(v.Snapshots) {
+		for _, pid := range sortedChanPIDs(snapshot) {
+			for _, msg := range snapshot.CommunicationChans[pid].Messages {
+				fmt.Fprintf(&b, "  P%d -> P%d [label=%q];\n", pid, snapshot.PID, msg.Message)
+			}
+		}
+		for pid, waiting := range snapshot.Waiting {
+			if waiting {
+				fmt.Fprintf(&b, "  P%d -> P%d [label=\"delays respOk\", style=dashed, color=red];\n", snapshot.PID, pid)
+			}
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// describeFields describes the fields of the snapshot of a process (except the communication
+// channels, which are described separately) as "name=value" pairs.
+func describeFields(s Snapshot) []string {
+	fields := make([]string, 0)
+	value := reflect.ValueOf(s)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		if name == "ID" || name == "PID" || name == "CommunicationChans" {
+			continue
+		}
+		fields = append(fields, fmt.Sprintf("%s=%s", name, formatField(value.Field(i).Interface())))
+	}
+	return fields
+}
+
+// diffFields describes the fields of the snapshot of a process that changed between two snapshots
+// (except the communication channels) as "name: old -> new" pairs.
+func diffFields(prev, curr Snapshot) []string {
+	changes := make([]string, 0)
+	prevValue, currValue := reflect.ValueOf(prev), reflect.ValueOf(curr)
+	for i := 0; i < currValue.NumField(); i++ {
+		name := currValue.Type().Field(i).Name
+		if name == "ID" || name == "PID" || name == "CommunicationChans" {
+			continue
+		}
+		before, after := prevValue.Field(i).Interface(), currValue.Field(i).Interface()
+		if !reflect.DeepEqual(before, after) {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, formatField(before), formatField(after)))
+		}
+	}
+	return changes
+}
+
+// formatField formats the value of a field of a snapshot. Flags per process (e.g., Waiting) are
+// formatted as the list of processes with the flag set.
+func formatField(v interface{}) string {
+	flags, ok := v.([]bool)
+	if !ok {
+		return fmt.Sprintf("%v", v)
+	}
+
+	pids := make([]string, 0)
+	for pid, flag := range flags {
+		if flag {
+			pids = append(pids, fmt.Sprintf("P%d", pid))
+		}
+	}
+	return "{" + strings.Join(pids, ",") + "}"
+}
+
+func stateColor(state common.State) string {
+	switch state {
+	case common.WantMX:
+		return "gold"
+	case common.InMX:
+		return "palegreen"
+	}
+	return "white"
+}
+
+func sortedByPID(snapshots []Snapshot) []Snapshot {
+	sorted := make([]Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PID < sorted[j].PID })
+	return sorted
+}
+
+func sortedChanPIDs(s Snapshot) []int {
+	pids := make([]int, 0, len(s.CommunicationChans))
+	for pid := range s.CommunicationChans {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
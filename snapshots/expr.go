@@ -0,0 +1,830 @@
+package snapshots
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"pucrs/sd/common"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled invariant expression that is evaluated over a global snapshot (i.e., the
+// set of local snapshots of all processes with the same snapshot ID). Expressions are written in
+// a small language, for example:
+//
+//	count(p in procs: p.State == InMX) <= 1
+//	forall p: any(p.Waiting) => p.State != NoMX
+//
+// The language supports:
+//   - integer literals, true, false and the state names NoMX, WantMX and InMX;
+//   - procs (the local snapshots of all processes) and N (the number of processes);
+//   - field access on a process snapshot (e.g., p.State, p.Waiting) and indexing of lists (e.g., p.Waiting[q.PID]);
+//   - the operators +, -, *, /, %, ==, !=, <, <=, >, >=, !, &&, || and => (implication);
+//   - the quantifiers "forall p [in procs]: <expr>" and "exists p [in procs]: <expr>";
+//   - the functions any(list), all(list), len(list), inTransit(p), count(list) and count(p in procs: <expr>).
+type Expr struct {
+	src  string
+	line int
+	root exprNode
+}
+
+// CompileExpr compiles the source of an invariant expression.
+func CompileExpr(src string) (*Expr, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("CompileExpr: %w", err)
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("CompileExpr: %w", err)
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("CompileExpr: unexpected '%s' at offset %d", tok.text, tok.pos)
+	}
+
+	return &Expr{src: src, root: root}, nil
+}
+
+// LoadExprInvariants reads and compiles the invariant expressions in the file at the given path.
+// The file contains one expression per line. Blank lines and lines starting with '#' are ignored.
+func LoadExprInvariants(path string) ([]*Expr, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadExprInvariants: failed to open file '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	exprs := make([]*Expr, 0)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		expr, err := CompileExpr(line)
+		if err != nil {
+			return nil, fmt.Errorf("LoadExprInvariants (%s:%d): %w", path, lineNo, err)
+		}
+		expr.line = lineNo
+		exprs = append(exprs, expr)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("LoadExprInvariants: failed reading file '%s': %w", path, err)
+	}
+
+	return exprs, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Check evaluates the expression over the provided snapshots. It has the same signature as the
+// built-in invariant checkers, so it can be registered next to them.
+//
+// Parameters:
+//
+//	snapshots - A variadic list of Snapshot objects representing the state
+//	            of different processes.
+//
+// Returns:
+//
+//	An error if the expression evaluates to false or cannot be evaluated, otherwise nil.
+func (e *Expr) Check(snapshots ...Snapshot) error {
+	env := &exprEnv{procs: snapshots, vars: make(map[string]interface{})}
+	v, err := e.root.eval(env)
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.describe(), err)
+	}
+
+	ok, isBool := v.(bool)
+	if !isBool {
+		return fmt.Errorf("%s: evaluated to %v, not to a boolean", e.describe(), v)
+	}
+	if !ok {
+		return fmt.Errorf("%s: evaluated to false", e.describe())
+	}
+	return nil
+}
+
+// describe returns the source of the expression, with its line in the file it was loaded from (if
+// it was not compiled directly with CompileExpr).
+func (e *Expr) describe() string {
+	if e.line == 0 {
+		return fmt.Sprintf("expression %q", e.src)
+	}
+	return fmt.Sprintf("expression %q (line %d)", e.src, e.line)
+}
+
+// ------------------------------------------------------------------------------------
+// ------- lexer
+// ------------------------------------------------------------------------------------
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokInt
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// exprOps lists the operators and punctuation of the language. Longer operators come first so
+// that they take precedence over their prefixes (e.g., "<=" over "<").
+var exprOps = []string{
+	"=>", "==", "!=", "<=", ">=", "&&", "||",
+	"<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ",", ":",
+}
+
+func lexExpr(src string) ([]token, error) {
+	tokens := make([]token, 0)
+
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(src) && unicode.IsDigit(rune(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokInt, text: src[start:i], pos: start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(src) && (unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i])) || src[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			matched := false
+			for _, op := range exprOps {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character '%c' at offset %d", c, i)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(src)}), nil
+}
+
+// ------------------------------------------------------------------------------------
+// ------- parser
+// ------------------------------------------------------------------------------------
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) isOp(ops ...string) bool {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) expectOp(op string) error {
+	if tok := p.next(); tok.kind != tokOp || tok.text != op {
+		return fmt.Errorf("expected '%s' at offset %d, found '%s'", op, tok.pos, tok.text)
+	}
+	return nil
+}
+
+func (p *exprParser) expectIdent() (string, error) {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return "", fmt.Errorf("expected identifier at offset %d, found '%s'", tok.pos, tok.text)
+	}
+	return tok.text, nil
+}
+
+// parseExpr parses an expression with the lowest precedence: implications.
+func (p *exprParser) parseExpr() (exprNode, error) {
+	lhs, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("=>") {
+		p.next()
+		rhs, err := p.parseExpr() // right associative
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: "=>", lhs: lhs, rhs: rhs}, nil
+	}
+	return lhs, nil
+}
+
+// parseBinder parses "<ident> [in <collection>] :", which introduces a variable in quantifiers
+// and in the count function. When the collection is omitted, it defaults to procs.
+func (p *exprParser) parseBinder() (string, exprNode, error) {
+	variable, err := p.expectIdent()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var domain exprNode = &identNode{name: "procs"}
+	if tok := p.peek(); tok.kind == tokIdent && tok.text == "in" {
+		p.next()
+		if domain, err = p.parsePostfix(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if err := p.expectOp(":"); err != nil {
+		return "", nil, err
+	}
+	return variable, domain, nil
+}
+
+func (p *exprParser) parseBinaryLevel(ops []string, operand func() (exprNode, error)) (exprNode, error) {
+	lhs, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(ops...) {
+		op := p.next().text
+		rhs, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryNode{op: op, lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseBinaryLevel([]string{"||"}, p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinaryLevel([]string{"&&"}, p.parseNot)
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.isOp("!") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "!", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	lhs, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("==", "!=", "<", "<=", ">", ">=") {
+		op := p.next().text
+		rhs, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: op, lhs: lhs, rhs: rhs}, nil
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	return p.parseBinaryLevel([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	return p.parseBinaryLevel([]string{"*", "/", "%"}, p.parseUnary)
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp("-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "-", operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			field, err := p.expectIdent()
+			if err != nil {
+				return nil, err
+			}
+			node = &fieldNode{target: node, field: field}
+		case p.isOp("["):
+			p.next()
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			node = &indexNode{target: node, index: index}
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+
+	switch {
+	case tok.kind == tokInt:
+		n, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer '%s' at offset %d", tok.text, tok.pos)
+		}
+		return &literalNode{value: n}, nil
+	case tok.kind == tokOp && tok.text == "(":
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case tok.kind == tokIdent && (tok.text == "forall" || tok.text == "exists"):
+		return p.parseQuantifier(tok)
+	case tok.kind == tokIdent && p.isOp("("):
+		return p.parseCall(tok)
+	case tok.kind == tokIdent:
+		return &identNode{name: tok.text}, nil
+	default:
+		return nil, fmt.Errorf("unexpected '%s' at offset %d", tok.text, tok.pos)
+	}
+}
+
+// parseQuantifier parses the binder and the body of a quantifier. Its body extends as far to the
+// right as possible, so "a && forall p: b || c" quantifies "b || c"; parentheses delimit it.
+func (p *exprParser) parseQuantifier(quantifier token) (exprNode, error) {
+	variable, domain, err := p.parseBinder()
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &quantifierNode{universal: quantifier.text == "forall", variable: variable, domain: domain, body: body}, nil
+}
+
+func (p *exprParser) parseCall(fn token) (exprNode, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+
+	// count(p in procs: <expr>) binds a variable instead of taking a list
+	if fn.text == "count" && p.peek().kind == tokIdent && p.tokens[p.pos+1].kind == tokIdent && p.tokens[p.pos+1].text == "in" {
+		variable, domain, err := p.parseBinder()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return &countNode{variable: variable, domain: domain, body: body}, nil
+	}
+
+	if _, ok := exprFuncs[fn.text]; !ok {
+		return nil, fmt.Errorf("unknown function '%s' at offset %d", fn.text, fn.pos)
+	}
+
+	args := make([]exprNode, 0)
+	for !p.isOp(")") {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+
+	return &callNode{name: fn.text, args: args}, nil
+}
+
+// ------------------------------------------------------------------------------------
+// ------- evaluation
+// ------------------------------------------------------------------------------------
+
+// exprEnv is the environment in which an expression is evaluated. Values are represented by
+// the Go types int, bool, []bool, Snapshot and []Snapshot.
+type exprEnv struct {
+	procs []Snapshot
+	vars  map[string]interface{}
+}
+
+type exprNode interface {
+	eval(env *exprEnv) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(_ *exprEnv) (interface{}, error) {
+	return n.value, nil
+}
+
+type identNode struct {
+	name string
+}
+
+func (n *identNode) eval(env *exprEnv) (interface{}, error) {
+	if v, ok := env.vars[n.name]; ok {
+		return v, nil
+	}
+
+	switch n.name {
+	case "procs":
+		return env.procs, nil
+	case "N":
+		return len(env.procs), nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "NoMX":
+		return int(common.NoMX), nil
+	case "WantMX":
+		return int(common.WantMX), nil
+	case "InMX":
+		return int(common.InMX), nil
+	}
+	return nil, fmt.Errorf("unknown identifier '%s'", n.name)
+}
+
+type fieldNode struct {
+	target exprNode
+	field  string
+}
+
+func (n *fieldNode) eval(env *exprEnv) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, ok := target.(Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("cannot access field '%s' of %v (not a process)", n.field, target)
+	}
+
+	field := reflect.ValueOf(snapshot).FieldByName(n.field)
+	if !field.IsValid() {
+		return nil, fmt.Errorf("unknown field '%s'", n.field)
+	}
+
+	switch v := field.Interface().(type) {
+	case int:
+		return v, nil
+	case common.State:
+		return int(v), nil
+	case bool:
+		return v, nil
+	case []bool:
+		return v, nil
+	}
+	return nil, fmt.Errorf("field '%s' is not supported in expressions", n.field)
+}
+
+type indexNode struct {
+	target exprNode
+	index  exprNode
+}
+
+func (n *indexNode) eval(env *exprEnv) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := evalInt(n.index, env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch list := target.(type) {
+	case []bool:
+		if index < 0 || index >= len(list) {
+			return nil, fmt.Errorf("index %d out of range [0, %d)", index, len(list))
+		}
+		return list[index], nil
+	case []Snapshot:
+		if index < 0 || index >= len(list) {
+			return nil, fmt.Errorf("index %d out of range [0, %d)", index, len(list))
+		}
+		return list[index], nil
+	}
+	return nil, fmt.Errorf("cannot index %v (not a list)", target)
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n *unaryNode) eval(env *exprEnv) (interface{}, error) {
+	if n.op == "!" {
+		v, err := evalBool(n.operand, env)
+		return !v, err
+	}
+	v, err := evalInt(n.operand, env)
+	return -v, err
+}
+
+type binaryNode struct {
+	op  string
+	lhs exprNode
+	rhs exprNode
+}
+
+func (n *binaryNode) eval(env *exprEnv) (interface{}, error) {
+	switch n.op {
+	case "&&", "||", "=>":
+		// short-circuit evaluation
+		lhs, err := evalBool(n.lhs, env)
+		if err != nil {
+			return nil, err
+		}
+		if (n.op == "&&" && !lhs) || (n.op == "=>" && !lhs) {
+			return n.op == "=>", nil
+		}
+		if n.op == "||" && lhs {
+			return true, nil
+		}
+		return evalBool(n.rhs, env)
+	case "==", "!=":
+		lhs, err := n.lhs.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := n.rhs.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if reflect.TypeOf(lhs) != reflect.TypeOf(rhs) {
+			return nil, fmt.Errorf("cannot compare %v and %v (mismatched types)", lhs, rhs)
+		}
+		return reflect.DeepEqual(lhs, rhs) == (n.op == "=="), nil
+	}
+
+	lhs, err := evalInt(n.lhs, env)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := evalInt(n.rhs, env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "<":
+		return lhs < rhs, nil
+	case "<=":
+		return lhs <= rhs, nil
+	case ">":
+		return lhs > rhs, nil
+	case ">=":
+		return lhs >= rhs, nil
+	case "+":
+		return lhs + rhs, nil
+	case "-":
+		return lhs - rhs, nil
+	case "*":
+		return lhs * rhs, nil
+	case "/", "%":
+		if rhs == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if n.op == "/" {
+			return lhs / rhs, nil
+		}
+		return lhs % rhs, nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+type quantifierNode struct {
+	universal bool
+	variable  string
+	domain    exprNode
+	body      exprNode
+}
+
+func (n *quantifierNode) eval(env *exprEnv) (interface{}, error) {
+	matches, total, err := countMatches(env, n.variable, n.domain, n.body)
+	if err != nil {
+		return nil, err
+	}
+	if n.universal {
+		return matches == total, nil
+	}
+	return matches > 0, nil
+}
+
+type countNode struct {
+	variable string
+	domain   exprNode
+	body     exprNode
+}
+
+func (n *countNode) eval(env *exprEnv) (interface{}, error) {
+	matches, _, err := countMatches(env, n.variable, n.domain, n.body)
+	return matches, err
+}
+
+// countMatches binds the variable to each process in the domain and counts for how many of them
+// the body evaluates to true. It also returns the size of the domain.
+func countMatches(env *exprEnv, variable string, domainNode, body exprNode) (int, int, error) {
+	domainValue, err := domainNode.eval(env)
+	if err != nil {
+		return 0, 0, err
+	}
+	domain, ok := domainValue.([]Snapshot)
+	if !ok {
+		return 0, 0, fmt.Errorf("cannot bind '%s' to %v (not a list of processes)", variable, domainValue)
+	}
+
+	shadowed, hadShadowed := env.vars[variable]
+	defer func() {
+		if hadShadowed {
+			env.vars[variable] = shadowed
+		} else {
+			delete(env.vars, variable)
+		}
+	}()
+
+	matches := 0
+	for _, snapshot := range domain {
+		env.vars[variable] = snapshot
+		ok, err := evalBool(body, env)
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok {
+			matches++
+		}
+	}
+	return matches, len(domain), nil
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+// exprFuncs are the built-in functions of the language.
+var exprFuncs = map[string]func(args []interface{}) (interface{}, error){
+	"any": func(args []interface{}) (interface{}, error) {
+		list, err := boolListArg("any", args)
+		if err != nil {
+			return nil, err
+		}
+		return common.Any(list, func(b bool) bool { return b }), nil
+	},
+	"all": func(args []interface{}) (interface{}, error) {
+		list, err := boolListArg("all", args)
+		if err != nil {
+			return nil, err
+		}
+		return common.All(list, func(b bool) bool { return b }), nil
+	},
+	"count": func(args []interface{}) (interface{}, error) {
+		list, err := boolListArg("count", args)
+		if err != nil {
+			return nil, err
+		}
+		return common.Count(list, func(b bool) bool { return b }), nil
+	},
+	"len": func(args []interface{}) (interface{}, error) {
+		if len(args) == 1 {
+			switch list := args[0].(type) {
+			case []bool:
+				return len(list), nil
+			case []Snapshot:
+				return len(list), nil
+			}
+		}
+		return nil, fmt.Errorf("len: expected a single list argument")
+	},
+	"inTransit": func(args []interface{}) (interface{}, error) {
+		if len(args) == 1 {
+			if snapshot, ok := args[0].(Snapshot); ok {
+				count := 0
+				for _, commChan := range snapshot.CommunicationChans {
+					count += len(commChan.Messages)
+				}
+				return count, nil
+			}
+		}
+		return nil, fmt.Errorf("inTransit: expected a single process argument")
+	},
+}
+
+func boolListArg(fn string, args []interface{}) ([]bool, error) {
+	if len(args) == 1 {
+		if list, ok := args[0].([]bool); ok {
+			return list, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: expected a single list of booleans argument", fn)
+}
+
+func (n *callNode) eval(env *exprEnv) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return exprFuncs[n.name](args)
+}
+
+func evalBool(node exprNode, env *exprEnv) (bool, error) {
+	v, err := node.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a boolean, found %v", v)
+	}
+	return b, nil
+}
+
+func evalInt(node exprNode, env *exprEnv) (int, error) {
+	v, err := node.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int)
+	if !ok {
+		return 0, fmt.Errorf("expected an integer, found %v", v)
+	}
+	return n, nil
+}
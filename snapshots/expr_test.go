@@ -0,0 +1,135 @@
+package snapshots
+
+import (
+	"pucrs/sd/common"
+	"strings"
+	"testing"
+)
+
+// exprTestSnapshots returns a global snapshot of 3 processes: P0 in the CS, P1 waiting for it
+// (with P0 delaying its response) and P2 idle.
+func exprTestSnapshots() []Snapshot {
+	return []Snapshot{
+		{PID: 0, State: common.InMX, Waiting: []bool{false, true, false}, ReqTs: 1},
+		{PID: 1, State: common.WantMX, Waiting: []bool{false, false, false}, ReqTs: 2, NbrResps: 1},
+		{PID: 2, State: common.NoMX, Waiting: []bool{false, false, false}},
+	}
+}
+
+func TestCompileExprEvaluates(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		// precedence
+		{"multiplicative before additive", "1 + 2 * 3 == 7", true},
+		{"parentheses", "(1 + 2) * 3 == 9", true},
+		{"left associative subtraction", "10 - 4 - 3 == 3", true},
+		{"unary minus", "-2 * 3 == -6", true},
+		{"modulo", "7 % 4 == 3", true},
+		{"and before or", "true || false && false", true},
+		{"not before and", "!false && false", false},
+		{"comparison before and", "1 < 2 && 2 < 3", true},
+		{"implication below or", "false || false => false", true},
+		{"implication right associative", "true => false => false", true},
+		{"implication false", "true => false", false},
+
+		// quantifiers
+		{"forall", "forall p: p.State != InMX || p.PID == 0", true},
+		{"exists", "exists p: p.State == WantMX", true},
+		{"explicit domain", "forall p in procs: p.PID < N", true},
+		{"quantifier after and", "true && forall p: p.PID >= 0", true},
+		{"quantifier after and (false)", "true && exists p: p.PID > 2", false},
+		{"quantifier body extends to the right", "false && exists p: p.PID == 0 || true", false},
+		{"quantifier in parentheses", "(forall p: p.PID < 3) && (exists q: q.State == NoMX)", true},
+		{"quantifier negated", "!(exists p: p.PID > 2)", true},
+		{"quantifier in implication", "exists p: p.State == InMX => forall q: q.PID == p.PID || q.State != InMX", true},
+		{"nested quantifiers", "forall p: forall q: p.Waiting[q.PID] => q.State == WantMX", true},
+		{"nested quantifiers (false)", "forall p: exists q: p.Waiting[q.PID]", false},
+		{"shadowed variable", "forall p: (exists p: p.PID == 2) && p.PID < 3", true},
+		{"quantifier in count", "count(p in procs: exists q: p.Waiting[q.PID]) == 1", true},
+		{"quantifier after function", "any(procs[0].Waiting) && forall p: p.PID < N", true},
+
+		// functions and fields
+		{"count", "count(p in procs: p.State == InMX) <= 1", true},
+		{"any", "any(procs[0].Waiting)", true},
+		{"len", "len(procs[1].Waiting) == N", true},
+		{"index by expression", "procs[1 + 1].State == NoMX", true},
+		{"fields", "procs[1].NbrResps == 1 && procs[1].ReqTs > procs[0].ReqTs", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := CompileExpr(tt.src)
+			if err != nil {
+				t.Fatalf("CompileExpr(%q): %v", tt.src, err)
+			}
+			err = expr.Check(exprTestSnapshots()...)
+			if got := err == nil; got != tt.want {
+				t.Errorf("Check(%q) = %v, want %v", tt.src, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileExprErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"unexpected character", "1 $ 2", "unexpected character '$' at offset 2"},
+		{"missing operand", "1 +", "unexpected 'end of expression' at offset 3"},
+		{"trailing token", "1 2", "unexpected '2' at offset 2"},
+		{"unclosed parenthesis", "(1 + 2", "expected ')' at offset 6, found 'end of expression'"},
+		{"unclosed index", "procs[0", "expected ']' at offset 7, found 'end of expression'"},
+		{"missing field", "procs[0].", "expected identifier at offset 9, found 'end of expression'"},
+		{"unknown function", "foo(1)", "unknown function 'foo' at offset 0"},
+		{"quantifier without colon", "forall p p.PID > 0", "expected ':' at offset 9, found 'p'"},
+		{"quantifier without variable", "true && forall : true", "expected identifier at offset 15, found ':'"},
+		{"quantifier without body", "(exists p:)", "unexpected ')' at offset 10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileExpr(tt.src)
+			if err == nil {
+				t.Fatalf("CompileExpr(%q) succeeded, want error %q", tt.src, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("CompileExpr(%q) = %q, want error containing %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprCheckErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"false", "N == 2", `expression "N == 2": evaluated to false`},
+		{"not a boolean", "N + 1", `expression "N + 1": evaluated to 4, not to a boolean`},
+		{"unknown identifier", "forall p: q.PID == 0", "unknown identifier 'q'"},
+		{"unknown field", "procs[0].Foo == 1", "unknown field 'Foo'"},
+		{"index out of range", "procs[3].PID == 3", "out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := CompileExpr(tt.src)
+			if err != nil {
+				t.Fatalf("CompileExpr(%q): %v", tt.src, err)
+			}
+			err = expr.Check(exprTestSnapshots()...)
+			if err == nil {
+				t.Fatalf("Check(%q) succeeded, want error %q", tt.src, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Check(%q) = %q, want error containing %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package snapshots
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// dimensions (in pixels) of the timeline rendered by RenderHTML
+const (
+	htmlCellWidth    = 90
+	htmlCellHeight   = 44
+	htmlRowGap       = 26
+	htmlLabelWidth   = 50
+	htmlHeaderHeight = 28
+)
+
+type htmlCell struct {
+	X, Y, Width, Height int
+	Color               string
+	Text                string
+	Tooltip             string
+}
+
+type htmlArrow struct {
+	X, Y1, Y2 int
+	Tooltip   string
+}
+
+type htmlColumn struct {
+	X, Width, Height int
+	SnapID           int
+	Violation        string
+}
+
+type htmlRow struct {
+	Y   int
+	PID int
+}
+
+type htmlTimeline struct {
+	Width, Height int
+	Rows          []htmlRow
+	Columns       []htmlColumn
+	Cells         []htmlCell
+	Arrows        []htmlArrow
+	Violations    []htmlColumn
+}
+
+var htmlTemplate = template.Must(template.New("timeline").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>DiMEx snapshots timeline</title>
+<style>
+  body { font-family: sans-serif; margin: 20px; }
+  svg text { font-size: 12px; }
+  .legend span { display: inline-block; padding: 2px 10px; margin-right: 8px; border: 1px solid #999; }
+  .violations li { color: #b00; margin-bottom: 4px; }
+</style>
+</head>
+<body>
+<h1>DiMEx snapshots timeline</h1>
+<p class="legend">
+  <span style="background: white">NoMX</span>
+  <span style="background: gold">WantMX</span>
+  <span style="background: palegreen">InMX</span>
+  &rarr; message in transit &nbsp; <span style="border: 2px solid red">invariant violation</span>
+</p>
+<div style="overflow-x: auto">
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+  <defs>
+    <marker id="arrowhead" markerWidth="8" markerHeight="8" refX="7" refY="4" orient="auto">
+      <path d="M0,0 L8,4 L0,8 z" fill="#1f4e9c"/>
+    </marker>
+  </defs>
+  {{range .Rows}}<text x="5" y="{{.Y}}" dominant-baseline="middle">P{{.PID}}</text>
+  {{end}}
+  {{range .Columns}}<text x="{{.X}}" y="15">#{{.SnapID}}</text>
+  {{end}}
+  {{range .Cells}}<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}" fill="{{.Color}}" stroke="#999"><title>{{.Tooltip}}</title></rect>
+  <text x="{{.X}}" y="{{.Y}}" dx="4" dy="16">{{.Text}}</text>
+  {{end}}
+  {{range .Arrows}}<line x1="{{.X}}" y1="{{.Y1}}" x2="{{.X}}" y2="{{.Y2}}" stroke="#1f4e9c" stroke-width="2" marker-end="url(#arrowhead)"><title>{{.Tooltip}}</title></line>
+  {{end}}
+  {{range .Violations}}<rect x="{{.X}}" y="20" width="{{.Width}}" height="{{.Height}}" fill="none" stroke="red" stroke-width="3"><title>{{.Violation}}</title></rect>
+  {{end}}
+</svg>
+</div>
+{{if .Violations}}
+<h2>Invariant violations</h2>
+<ul class="violations">
+  {{range .Violations}}<li>Snapshot #{{.SnapID}}: {{.Violation}}</li>
+  {{end}}
+</ul>
+{{else}}
+<p>No invariant violations.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// RenderHTML renders a timeline of the given snapshot sets (as returned by ParseVerifyAll) as a
+// self-contained static HTML page. The timeline has one row per process and one column per
+// snapshot ID, with cells coloured by the state of the process. Messages in transit are drawn as
+// arrows from the row of the sender to the row of the receiver, and the columns of the snapshot
+// sets that violate an invariant are highlighted.
+func RenderHTML(w io.Writer, sets [][]Snapshot, violations map[int]error) error {
+	nProcesses := 0
+	if len(sets) > 0 {
+		nProcesses = len(sets[0])
+	}
+
+	rowY := func(pid int) int {
+		return htmlHeaderHeight + pid*(htmlCellHeight+htmlRowGap)
+	}
+
+	timeline := htmlTimeline{
+		Width:  htmlLabelWidth + len(sets)*htmlCellWidth + 10,
+		Height: rowY(nProcesses) + 10,
+	}
+
+	for pid := 0; pid < nProcesses; pid++ {
+		timeline.Rows = append(timeline.Rows, htmlRow{Y: rowY(pid) + htmlCellHeight/2, PID: pid})
+	}
+
+	for col, set := range sets {
+		x := htmlLabelWidth + col*htmlCellWidth
+		column := htmlColumn{X: x, Width: htmlCellWidth, Height: rowY(nProcesses) - 20, SnapID: set[0].ID}
+		timeline.Columns = append(timeline.Columns, column)
+		if err, ok := violations[column.SnapID]; ok {
+			column.Violation = err.Error()
+			timeline.Violations = append(timeline.Violations, column)
+		}
+
+		for _, snapshot := range set {
+			timeline.Cells = append(timeline.Cells, htmlCell{
+				X:       x,
+				Y:       rowY(snapshot.PID),
+				Width:   htmlCellWidth,
+				Height:  htmlCellHeight,
+				Color:   stateColor(snapshot.State),
+				Text:    fmt.Sprintf("%s ts=%d", snapshot.State, snapshot.ReqTs),
+				Tooltip: fmt.Sprintf("P%d @ snapshot %d\n%s", snapshot.PID, snapshot.ID, strings.Join(describeFields(snapshot), "\n")),
+			})
+		}
+
+		// spread the arrows of the column horizontally so that they do not overlap
+		arrows := make([]htmlArrow, 0)
+		for _, snapshot := range set {
+			for _, pid := range sortedChanPIDs(snapshot) {
+				for _, msg := range snapshot.CommunicationChans[pid].Messages {
+					arrows = append(arrows, htmlArrow{
+						Y1:      rowY(pid) + htmlCellHeight/2,
+						Y2:      arrowEnd(rowY(pid), rowY(snapshot.PID)),
+						Tooltip: fmt.Sprintf("P%d -> P%d: %s", pid, snapshot.PID, msg.Message),
+					})
+				}
+			}
+		}
+		for i := range arrows {
+			arrows[i].X = x + (i+1)*htmlCellWidth/(len(arrows)+1)
+		}
+		timeline.Arrows = append(timeline.Arrows, arrows...)
+	}
+
+	if err := htmlTemplate.Execute(w, timeline); err != nil {
+		return fmt.Errorf("RenderHTML: failed rendering the timeline: %w", err)
+	}
+	return nil
+}
+
+// arrowEnd returns the y coordinate where an arrow from the row of the sender to the row of the
+// receiver ends, so that the arrowhead touches the border of the cell of the receiver.
+func arrowEnd(senderY, receiverY int) int {
+	if receiverY > senderY {
+		return receiverY
+	}
+	return receiverY + htmlCellHeight
+}
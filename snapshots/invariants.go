@@ -3,6 +3,7 @@ package snapshots
 import (
 	"fmt"
 	"pucrs/sd/common"
+	"pucrs/sd/pp2plink"
 )
 
 // invariantCheckerFunc is a function type that checks invariants on a set of snapshots.
@@ -10,7 +11,7 @@ type invariantCheckerFunc func(snapshots ...Snapshot) error
 
 // checkMutualExclusion verifies that the mutual exclusion property is upheld
 // across the provided snapshots. It ensures that at most one process is in
-// the critical section at any given time.
+// the critical section in exclusive mode (a writer) at any given time.
 //
 // Parameters:
 //
@@ -19,11 +20,11 @@ type invariantCheckerFunc func(snapshots ...Snapshot) error
 //
 // Returns:
 //
-//	An error if more than one process is found to be in the critical section,
-//	otherwise nil.
+//	An error if more than one process is found to be in the critical section
+//	in exclusive mode, otherwise nil.
 func checkMutualExclusion(snapshots ...Snapshot) error {
 	inCSCount := common.Count(snapshots, func(s Snapshot) bool {
-		return s.State == common.InMX
+		return s.State == common.InMX && !s.Shared
 	})
 	if inCSCount > 1 {
 		return fmt.Errorf("checkMutualExclusion: %d processes in critical section (more than 1)", inCSCount)
@@ -31,6 +32,37 @@ func checkMutualExclusion(snapshots ...Snapshot) error {
 	return nil
 }
 
+// checkNoWriterWithOtherHolders verifies that a process in the critical section in exclusive mode
+// (a writer) is not concurrent with any other holder of the critical section, i.e., only processes
+// in shared mode (readers) hold the critical section together.
+//
+// Parameters:
+//
+//	snapshots - A variadic list of Snapshot objects representing the state
+//	            of different processes.
+//
+// Returns:
+//
+//	An error if a process is in the critical section in exclusive mode together with another
+//	process, otherwise nil.
+func checkNoWriterWithOtherHolders(snapshots ...Snapshot) error {
+	for _, writer := range snapshots {
+		if writer.State != common.InMX || writer.Shared {
+			continue
+		}
+		for _, other := range snapshots {
+			if other.PID != writer.PID && other.State == common.InMX {
+				return fmt.Errorf(
+					"checkNoWriterWithOtherHolders: process %d is in MX in exclusive mode together with process %d",
+					writer.PID,
+					other.PID,
+				)
+			}
+		}
+	}
+	return nil
+}
+
 // checkWaitingImpliesWantOrInCS verifies that for each snapshot provided, if the process
 // is delaying entry responses to other processes, then it must either be in the "InMX"
 // state (critical section) or the "WantMX" state (intending to enter the critical section).
@@ -88,7 +120,8 @@ func checkIdleProcessesState(snapshots ...Snapshot) error {
 }
 
 // checkOnlyInMXWithAllConsent verifies that a process in the critical section has received the
-// responses from all other processes to the entry request.
+// responses from all other processes to the entry request, except the ones it suspects: with the
+// perfect failure detector, a process enters without the responses of the suspected processes.
 //
 // Parameters:
 //
@@ -103,9 +136,16 @@ func checkOnlyInMXWithAllConsent(snapshots ...Snapshot) error {
 	nProcesses := len(snapshots)
 
 	for _, snapshot := range snapshots {
-		isInMX := snapshot.State == common.InMX
-		allCollectedResps := snapshot.NbrResps >= (nProcesses - 1) // don't count itself (>= needed for failure simulation)
-		if isInMX && !allCollectedResps {
+		if snapshot.State != common.InMX {
+			continue
+		}
+		excused := 0
+		for _, othSnapshot := range snapshots {
+			if othSnapshot.PID != snapshot.PID && snapshot.IsSuspected(othSnapshot.PID) {
+				excused++
+			}
+		}
+		if snapshot.NbrResps+excused < nProcesses-1 { // don't count itself
 			return fmt.Errorf(
 				"checkOnlyInMXWithAllConsent: process %d is in MX but not all responses received (only %d)",
 				snapshot.PID,
@@ -118,7 +158,8 @@ func checkOnlyInMXWithAllConsent(snapshots ...Snapshot) error {
 }
 
 // checkNotOtherDelaysWhenInMX verifies that if a process is in the critical section, no other
-// process is delaying the entry response to it.
+// process is delaying the entry response to it, except the ones it suspects (see
+// checkOnlyInMXWithAllConsent).
 //
 // Parameters:
 //
@@ -135,7 +176,7 @@ func checkNotOtherDelaysWhenInMX(snapshots ...Snapshot) error {
 		}
 
 		for _, othSnapshot := range snapshots {
-			if othSnapshot.Waiting[snapshot.PID] {
+			if isWaiting(othSnapshot, snapshot.PID) && !snapshot.IsSuspected(othSnapshot.PID) {
 				return fmt.Errorf(
 					"checkNotOtherDelaysWhenInMX: process %d is in MX but process %d is delaying the entry response",
 					snapshot.PID,
@@ -176,3 +217,122 @@ func checkNotDelayingWhenNoMX(snapshots ...Snapshot) error {
 
 	return nil
 }
+
+// checkPermissionsAccounting verifies that, for every process in the WantMX state, the
+// permissions (respOk messages) to its pending entry request are all accounted for. The
+// permissions are either already received (NbrResps), in transit in the recorded communication
+// channels, owed by peers that are delaying the response (Waiting flags), or not yet granted
+// because the entry request itself is still in transit to the peer. Together, they must amount
+// to exactly one permission from each other process.
+//
+// Processes that suspect another process (see the failure detector) are not checked, since the
+// permissions of suspected processes are neither waited for nor accounted for in the snapshots.
+// Neither are processes whose view of the group differs from the processes in the global snapshot
+// (i.e., while processes join or leave the group).
+//
+// Parameters:
+//
+//	snapshots - A variadic parameter representing a list of Snapshot objects to be checked.
+//
+// Returns:
+//
+//	error - Returns an error if the permissions of a process in the WantMX state do not add up to
+//	        the number of other processes (e.g., due to lost or duplicated messages). Returns nil if
+//	        no such violations are found.
+func checkPermissionsAccounting(snapshots ...Snapshot) error {
+	nProcesses := len(snapshots)
+
+	for _, snapshot := range snapshots {
+		if snapshot.State != common.WantMX || common.Any(snapshot.Suspected, func(s bool) bool { return s }) {
+			continue
+		}
+		if snapshot.Members != nil && common.Count(snapshot.Members, func(m bool) bool { return m }) != nProcesses {
+			continue
+		}
+
+		received := snapshot.NbrResps
+		inTransit, owed, notDelivered := 0, 0, 0
+		for _, othSnapshot := range snapshots {
+			if othSnapshot.PID == snapshot.PID {
+				continue
+			}
+			inTransit += countMessages(snapshot.CommunicationChans[othSnapshot.PID], common.RESP_OK)
+			notDelivered += countMessages(othSnapshot.CommunicationChans[snapshot.PID], common.REQ_ENTRY)
+			if isWaiting(othSnapshot, snapshot.PID) {
+				owed++
+			}
+		}
+
+		if total := received + inTransit + owed + notDelivered; total != nProcesses-1 {
+			return fmt.Errorf(
+				"checkPermissionsAccounting: process %d accounts for %d permissions instead of %d "+
+					"(%d received, %d in transit, %d owed, %d requests in transit)",
+				snapshot.PID,
+				total,
+				nProcesses-1,
+				received,
+				inTransit,
+				owed,
+				notDelivered,
+			)
+		}
+	}
+
+	return nil
+}
+
+// checkLeases verifies that the leases of the critical section are only held inside it: a process
+// with time left of a lease must be in the critical section, a process in the critical section
+// under a lease must not have held it past the lease, and a process whose lease expired must have
+// been taken out of the critical section (so it no longer blocks the other processes).
+//
+// Parameters:
+//
+//	snapshots - A variadic parameter representing a list of Snapshot objects to be checked.
+//
+// Returns:
+//
+//	error - Returns an error if a process holds a lease outside the critical section, holds the
+//	        critical section past its lease, or is still in the critical section after its lease
+//	        expired. Returns nil if no such violations are found.
+func checkLeases(snapshots ...Snapshot) error {
+	for _, snapshot := range snapshots {
+		switch {
+		case snapshot.Lease > 0 && snapshot.State != common.InMX:
+			return fmt.Errorf(
+				"checkLeases: process %d holds a lease (%v left) but is not in MX",
+				snapshot.PID,
+				snapshot.Lease,
+			)
+		case snapshot.Lease < 0:
+			return fmt.Errorf(
+				"checkLeases: process %d holds the CS %v past its lease",
+				snapshot.PID,
+				-snapshot.Lease,
+			)
+		case snapshot.Expired && snapshot.State == common.InMX:
+			return fmt.Errorf(
+				"checkLeases: process %d is in MX but its lease expired",
+				snapshot.PID,
+			)
+		}
+	}
+
+	return nil
+}
+
+// isWaiting tells whether the snapshot records the process as delaying the response to the process
+// with the given PID (which may have joined the group after the snapshot was taken).
+func isWaiting(snapshot Snapshot, pid int) bool {
+	return pid < len(snapshot.Waiting) && snapshot.Waiting[pid]
+}
+
+// countMessages counts the messages of the given kind recorded in the communication channel.
+func countMessages(commChan *communicationChan, kind string) int {
+	if commChan == nil {
+		return 0
+	}
+	return common.Count(commChan.Messages, func(msg pp2plink.IndMsg) bool {
+		return common.MessageKind(msg.Message) == kind
+	})
+}
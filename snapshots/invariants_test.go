@@ -0,0 +1,39 @@
+package snapshots
+
+import (
+	"pucrs/sd/common"
+	"testing"
+)
+
+func TestInvariantsExcuseSuspectedProcesses(t *testing.T) {
+	// P0 in the CS with the response of P1 only; P2 is delaying the response to it
+	inMX := func(suspected []bool) []Snapshot {
+		return []Snapshot{
+			{PID: 0, State: common.InMX, Waiting: []bool{false, false, false}, ReqTs: 1, NbrResps: 1, Suspected: suspected},
+			{PID: 1, State: common.NoMX, Waiting: []bool{false, false, false}},
+			{PID: 2, State: common.InMX, Waiting: []bool{true, false, false}, ReqTs: 2, NbrResps: 2},
+		}
+	}
+	tests := []struct {
+		name      string
+		checker   invariantCheckerFunc
+		suspected []bool
+		wantErr   bool
+	}{
+		{"missing response", checkOnlyInMXWithAllConsent, nil, true},
+		{"missing response of a suspected process", checkOnlyInMXWithAllConsent, []bool{false, false, true}, false},
+		{"missing response with another process suspected", checkOnlyInMXWithAllConsent, []bool{false, true, false}, false},
+		{"delayed response", checkNotOtherDelaysWhenInMX, nil, true},
+		{"delayed response of a suspected process", checkNotOtherDelaysWhenInMX, []bool{false, false, true}, false},
+		{"delayed response of another process", checkNotOtherDelaysWhenInMX, []bool{false, true, false}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.checker(inMX(tt.suspected)...)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("checker = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
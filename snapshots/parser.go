@@ -5,31 +5,65 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
 )
 
 type parser struct {
-	files             []*os.File
-	scanners          []*bufio.Scanner
-	invariantCheckers []invariantCheckerFunc
+	dumpFiles map[string]int // snapshot files to be parsed and the PID of the process of each one
+	files     []*os.File
+	scanners  []*bufio.Scanner
+	next      []*Snapshot // snapshot read ahead from each scanner, not yet part of a set
+	verifier  *verifier
 }
 
 // NewParser creates a new instance of a Parser, which can then be used for verifying the snapshots
 // taken by the processes during the execution of the mutual exclusion algorithm. The user is
 // responsible for calling Init() before performing any operations on the Parser instance, and
 // Close() after all operations are completed to ensure proper resource management.
-func NewParser() *parser {
+func NewParser(opts ...VerifierOpt) *parser {
+	dumpFilesMu.RLock()
+	defer dumpFilesMu.RUnlock()
+
+	files := make(map[string]int, len(dumpFiles))
+	for dumpFile, pid := range dumpFiles {
+		files[dumpFile] = pid
+	}
+
+	return newParser(files, opts...)
+}
+
+// NewFilesParser creates a new instance of a Parser for the given snapshot files, which may have
+// been generated by a previous execution. The PID of the process of each file is taken from its
+// name (snapshots-pid-<n>.txt), and there must be exactly one file for each PID from 0 to n-1.
+// Like with NewParser, the user is responsible for calling Init() and Close().
+func NewFilesParser(paths []string, opts ...VerifierOpt) (*parser, error) {
+	files := make(map[string]int, len(paths))
+	seen := make([]bool, len(paths))
+	for _, path := range paths {
+		var pid int
+		if _, err := fmt.Sscanf(filepath.Base(path), dumpFileFmt, &pid); err != nil {
+			return nil, fmt.Errorf("NewFilesParser: failed to get the PID from the name of file '%s': %w", path, err)
+		}
+		if pid < 0 || pid >= len(paths) || seen[pid] {
+			return nil, fmt.Errorf("NewFilesParser: unexpected PID %d in the name of file '%s'", pid, path)
+		}
+		seen[pid] = true
+		files[path] = pid
+	}
+
+	return newParser(files, opts...), nil
+}
+
+func newParser(dumpFiles map[string]int, opts ...VerifierOpt) *parser {
 	nProcesses := len(dumpFiles)
 	p := &parser{
-		files:    make([]*os.File, nProcesses),
-		scanners: make([]*bufio.Scanner, nProcesses),
-		invariantCheckers: []invariantCheckerFunc{
-			checkMutualExclusion,
-			checkWaitingImpliesWantOrInCS,
-			checkIdleProcessesState,
-			checkOnlyInMXWithAllConsent,
-			checkNotOtherDelaysWhenInMX,
-			checkNotDelayingWhenNoMX,
-		},
+		dumpFiles: dumpFiles,
+		files:     make([]*os.File, nProcesses),
+		scanners:  make([]*bufio.Scanner, nProcesses),
+		next:      make([]*Snapshot, nProcesses),
+		verifier:  newVerifier(opts...),
 	}
 
 	return p
@@ -37,10 +71,7 @@ func NewParser() *parser {
 
 // Init initializes the Parser instance.
 func (p *parser) Init() error {
-	dumpFilesMu.RLock()
-	defer dumpFilesMu.RUnlock()
-
-	for dumpFile, pid := range dumpFiles {
+	for dumpFile, pid := range p.dumpFiles {
 		file, err := os.Open(dumpFile)
 		if err != nil {
 			return fmt.Errorf("parser.Init: failed to open file '%s': %w", dumpFile, err)
@@ -63,7 +94,8 @@ func (p *parser) Close() error {
 
 // ParseVerify iterates through all snapshot files, parses and validates them using
 // the registered invariant checkers. It ensures that each snapshot set adheres to
-// the defined invariants.
+// the defined invariants, and that the sequence of snapshot sets adheres to the
+// defined temporal invariants.
 //
 // If an error occurs while reading the snapshots or if an invariant violation
 // is detected, the method immediately aborts and returns an error with detailed
@@ -84,10 +116,8 @@ func (p *parser) ParseVerify() error {
 			break
 		}
 
-		for _, checker := range p.invariantCheckers {
-			if err := checker(*snapshots...); err != nil {
-				return fmt.Errorf("parser.ParseVerify (snapId %d): invariant violation: %w", snapId, err)
-			}
+		if err := p.verifier.verify(*snapshots); err != nil {
+			return fmt.Errorf("parser.ParseVerify (snapId %d): %w", snapId, err)
 		}
 
 		snapId++
@@ -96,6 +126,33 @@ func (p *parser) ParseVerify() error {
 	return nil
 }
 
+// ParseVerifyAll iterates through all snapshot files like ParseVerify, but does not stop at the
+// first invariant violation. It returns all the snapshot sets, in order, and the violations
+// found, by snapshot ID. An error is only returned if the snapshots cannot be read.
+func (p *parser) ParseVerifyAll() ([][]Snapshot, map[int]error, error) {
+	sets := make([][]Snapshot, 0)
+	violations := make(map[int]error)
+
+	for snapId := 0; ; snapId++ {
+		snapshots, err := p.getNextSnapshotsSet()
+		if err != nil {
+			return nil, nil, fmt.Errorf("parser.ParseVerifyAll (snapId %d): error reading snapshots: %w", snapId, err)
+		}
+
+		if snapshots == nil {
+			// no more snapshots to verify
+			break
+		}
+
+		sets = append(sets, *snapshots)
+		if err := p.verifier.verify(*snapshots); err != nil {
+			violations[(*snapshots)[0].ID] = err
+		}
+	}
+
+	return sets, violations, nil
+}
+
 // getNextSnapshotsSet reads the next set of snapshots from all scanners in the Parser.
 // It expects each scanner to provide a JSON-encoded snapshot, which is unmarshaled into
 // a Snapshot struct. The function ensures that all scanners have the same number of snapshots.
@@ -104,18 +161,34 @@ func (p *parser) ParseVerify() error {
 //   - A pointer to a slice of Snapshot structs if all scanners successfully provide a snapshot.
 //   - An error if any scanner encounters an issue (e.g., read error, unmarshaling error, or mismatched snapshot counts).
 //   - A nil pointer and no error if all scanners have reached EOF and there are no more snapshots to read.
+//
+// The last snapshot may be incomplete: if the system terminates while a snapshot is in progress,
+// only some processes have dumped it. Such a set is the last one of every process that has it, so
+// it is skipped (with a warning) instead of being reported as mismatched snapshot counts.
+//
+// A process may also crash or leave the group, and stop dumping snapshots, while the others go on.
+// If all the snapshots of a set record the processes that reached EOF as suspected by the failure
+// detector or as not members of the group, those processes are left out of this and the next sets.
+// Likewise, a process that joined the group afterwards is left out of the sets with snapshot IDs
+// lower than that of its first snapshot.
 func (p *parser) getNextSnapshotsSet() (*[]Snapshot, error) {
-	nProcesses := len(p.scanners)
-	snapshots := make([]Snapshot, nProcesses)
-	eofCount := 0
+	nProcesses := 0
+	eofPIDs := make([]int, 0)
 
 	for pid, scanner := range p.scanners {
+		if scanner == nil {
+			continue // this process has crashed or left the group
+		}
+		nProcesses++
+		if p.next[pid] != nil {
+			continue // read ahead, since the process joined the group afterwards
+		}
 		if !scanner.Scan() {
 			if scanner.Err() != nil {
 				return nil, fmt.Errorf("parser.getNextSnapshotsSet (pid %d): error reading line: %w", pid, scanner.Err())
 			}
 			// this scanner has reached EOF
-			eofCount++
+			eofPIDs = append(eofPIDs, pid)
 			continue
 		}
 
@@ -123,7 +196,42 @@ func (p *parser) getNextSnapshotsSet() (*[]Snapshot, error) {
 		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
 			return nil, fmt.Errorf("parser.getNextSnapshotsSet (pid %d): error unmarshaling snapshot: %w", pid, err)
 		}
-		snapshots[pid] = s
+		p.next[pid] = &s
+	}
+	eofCount := len(eofPIDs)
+
+	if nProcesses == 0 {
+		// no scanners to read from
+		return nil, nil
+	}
+
+	// the set is made of the snapshots with the lowest ID
+	snapId := -1
+	for _, next := range p.next {
+		if next != nil && (snapId < 0 || next.ID < snapId) {
+			snapId = next.ID
+		}
+	}
+	snapshots := make([]Snapshot, 0, nProcesses)
+	laterPIDs := make([]int, 0)
+	for pid, next := range p.next {
+		if next == nil {
+			continue
+		}
+		if next.ID != snapId {
+			laterPIDs = append(laterPIDs, pid)
+			continue
+		}
+		snapshots = append(snapshots, *next)
+		p.next[pid] = nil
+	}
+
+	if len(laterPIDs) > 0 && !allAbsent(snapshots, laterPIDs) {
+		return nil, fmt.Errorf(
+			"parser.getNextSnapshotsSet: processes %v are missing from snapshot %d, but are members of the group",
+			laterPIDs,
+			snapId,
+		)
 	}
 
 	if eofCount == 0 {
@@ -131,7 +239,15 @@ func (p *parser) getNextSnapshotsSet() (*[]Snapshot, error) {
 		return &snapshots, nil
 	}
 
-	if eofCount != nProcesses {
+	if eofCount != nProcesses && allAbsent(snapshots, eofPIDs) {
+		// the processes that reached EOF have crashed or left the group
+		for _, pid := range eofPIDs {
+			p.scanners[pid] = nil
+		}
+		return &snapshots, nil
+	}
+
+	if eofCount != nProcesses && p.hasMoreSnapshots() {
 		// only some scanners have reached EOF (other scanners still have snapshots)
 		return nil, fmt.Errorf(
 			"parser.getNextSnapshotsSet: %d processes have reached EOF, but %d processes still have snapshots remaining",
@@ -140,6 +256,38 @@ func (p *parser) getNextSnapshotsSet() (*[]Snapshot, error) {
 		)
 	}
 
+	if eofCount != nProcesses {
+		logrus.Warnf(
+			"parser.getNextSnapshotsSet: skipping last snapshot, which only %d of %d processes completed before terminating",
+			nProcesses-eofCount,
+			nProcesses,
+		)
+	}
+
 	// all scanners have reached EOF - no more snapshots to read
 	return nil, nil
 }
+
+// hasMoreSnapshots tells whether any scanner still has snapshots to read (advancing it).
+func (p *parser) hasMoreSnapshots() bool {
+	more := false
+	for pid, scanner := range p.scanners {
+		if scanner != nil && (p.next[pid] != nil || scanner.Scan()) {
+			more = true
+		}
+	}
+	return more
+}
+
+// allAbsent tells whether all the given snapshots record all the given processes as suspected or
+// as not members of the group.
+func allAbsent(snapshots []Snapshot, pids []int) bool {
+	for _, s := range snapshots {
+		for _, pid := range pids {
+			if s.IsMember(pid) && !s.IsSuspected(pid) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -7,8 +7,12 @@ import (
 	"pucrs/sd/common"
 	"pucrs/sd/pp2plink"
 	"sync"
+	"time"
 )
 
+// dumpFileFmt is the format of the name of the file to which the snapshots of a process are dumped.
+const dumpFileFmt = "snapshots-pid-%d.txt"
+
 var (
 	dumpFiles   = make(map[string]int)
 	dumpFilesMu sync.RWMutex
@@ -24,6 +28,12 @@ type ProcessState struct {
 	LocalClock int
 	ReqTs      int
 	NbrResps   int
+	Suspected  []bool        // processes suspected by the failure detector (nil if there is none)
+	Members    []bool        // processes in the group, if it changed since the start (nil otherwise)
+	Shared     bool          // whether the request to the CS pending or held by the process is shared (reader)
+	Priority   int           // priority class of the last request to the CS of the process
+	Lease      time.Duration // time left of the lease of the CS held by the process (0 without a lease)
+	Expired    bool          // whether the lease of the CS expired before the application exited it
 }
 
 // communicationChan is a struct that represents the abstraction of a communication
@@ -69,6 +79,30 @@ type Snapshot struct {
 	ReqTs      int
 	NbrResps   int
 
+	// Processes suspected by the failure detector when this snapshot was taken or while it was in
+	// progress (nil if there is no failure detector). Their channels are closed without waiting for
+	// their snapshot message, and crashed processes are missing from the global snapshot.
+	Suspected []bool `json:",omitempty"`
+
+	// Processes in the group in the view of this process when this snapshot was taken (nil if the
+	// group has not changed since the start, i.e., all processes are members). Processes that
+	// joined the group afterwards or left it are missing from the global snapshot.
+	Members []bool `json:",omitempty"`
+
+	// Whether the request to the CS pending or held by the process is shared (a reader, which may
+	// hold the CS together with other readers) rather than exclusive (a writer).
+	Shared bool `json:",omitempty"`
+
+	// Priority class of the last request to the CS of the process (0 by default). Requests of a
+	// higher class are granted the CS first, subject to aging.
+	Priority int `json:",omitempty"`
+
+	// Time left of the lease of the CS held by the process when this snapshot was taken (0 if the
+	// process is not in the CS or holds it without a lease), and whether the lease of the last CS
+	// expired (i.e., the CS was released by the DIMEX module before the application exited it).
+	Lease   time.Duration `json:",omitempty"`
+	Expired bool          `json:",omitempty"`
+
 	// Communication chans between this process and the process with the PID in the key
 	// Used for storing messages in transit when this snapshot was taken
 	CommunicationChans map[int]*communicationChan
@@ -86,6 +120,12 @@ func NewSnapshot(state ProcessState) *Snapshot {
 		LocalClock:         state.LocalClock,
 		ReqTs:              state.ReqTs,
 		NbrResps:           state.NbrResps,
+		Suspected:          state.Suspected,
+		Members:            state.Members,
+		Shared:             state.Shared,
+		Priority:           state.Priority,
+		Lease:              state.Lease,
+		Expired:            state.Expired,
 		CommunicationChans: make(map[int]*communicationChan, nProcesses),
 	}
 
@@ -102,7 +142,7 @@ func NewSnapshot(state ProcessState) *Snapshot {
 
 // DumpToFile appends the dump of the snapshot to a file in JSON format.
 func (s *Snapshot) DumpToFile() error {
-	path := fmt.Sprintf("snapshots-pid-%d.txt", s.PID)
+	path := fmt.Sprintf(dumpFileFmt, s.PID)
 
 	dumpFilesMu.Lock()
 	dumpFiles[path] = s.PID // store the name of the file and the PID of the process for later parsing
@@ -136,6 +176,29 @@ func (s *Snapshot) HasMessagesInTransit() bool {
 	return false
 }
 
+// IsSuspected tells whether this snapshot records the process with the given PID as suspected.
+func (s *Snapshot) IsSuspected(pid int) bool {
+	return pid < len(s.Suspected) && s.Suspected[pid]
+}
+
+// IsMember tells whether this snapshot records the process with the given PID as a member of the
+// group.
+func (s *Snapshot) IsMember(pid int) bool {
+	if s.Members == nil {
+		return pid < len(s.Waiting)
+	}
+	return pid < len(s.Members) && s.Members[pid]
+}
+
+// CloseChan closes the communication channel from the process with the given PID, creating it if
+// this snapshot has none (e.g., the process joined the group after this snapshot was taken).
+func (s *Snapshot) CloseChan(pid int) {
+	if _, ok := s.CommunicationChans[pid]; !ok {
+		s.CommunicationChans[pid] = &communicationChan{Messages: make([]pp2plink.IndMsg, 0)}
+	}
+	s.CommunicationChans[pid].Close()
+}
+
 // IsOver tells whether or not this snapshot is over (completed) and therefore can
 // be dumped to a file.
 func (s *Snapshot) IsOver() bool {
@@ -0,0 +1,222 @@
+package snapshots
+
+import (
+	"fmt"
+	"pucrs/sd/common"
+)
+
+// temporalCheckerFunc is a function type that checks invariants on a sequence of consecutive
+// global snapshots (sets of snapshots with the same ID), ordered from the oldest to the most
+// recent one. Each checker only looks at as many of the most recent sets as it needs.
+type temporalCheckerFunc func(history ...[]Snapshot) error
+
+// checkLocalClockMonotonic verifies that the local (Lamport) clock of every process never
+// decreases between two consecutive global snapshots.
+//
+// Parameters:
+//
+//	history - A variadic parameter representing the sequence of global snapshots to be checked.
+//
+// Returns:
+//
+//	error - Returns an error if the local clock of a process has decreased. Returns nil otherwise.
+func checkLocalClockMonotonic(history ...[]Snapshot) error {
+	return forEachConsecutive(history, func(prev, curr Snapshot) error {
+		if curr.LocalClock < prev.LocalClock {
+			return fmt.Errorf(
+				"checkLocalClockMonotonic: local clock of process %d decreased from %d to %d",
+				curr.PID,
+				prev.LocalClock,
+				curr.LocalClock,
+			)
+		}
+		return nil
+	})
+}
+
+// checkReqTsNonDecreasing verifies that the timestamp of the last entry request of every
+// process never decreases between two consecutive global snapshots.
+//
+// Parameters:
+//
+//	history - A variadic parameter representing the sequence of global snapshots to be checked.
+//
+// Returns:
+//
+//	error - Returns an error if the request timestamp of a process has decreased. Returns nil otherwise.
+func checkReqTsNonDecreasing(history ...[]Snapshot) error {
+	return forEachConsecutive(history, func(prev, curr Snapshot) error {
+		if curr.ReqTs < prev.ReqTs {
+			return fmt.Errorf(
+				"checkReqTsNonDecreasing: request timestamp of process %d decreased from %d to %d",
+				curr.PID,
+				prev.ReqTs,
+				curr.ReqTs,
+			)
+		}
+		return nil
+	})
+}
+
+// checkLeavesInMXThroughNoMX verifies that a process only leaves the critical section by
+// releasing it (going to the NoMX state). Since the process may go through several states
+// between two snapshots, a process that was InMX and is WantMX in the next snapshot must have
+// issued a new entry request (i.e., its request timestamp must have changed).
+//
+// Parameters:
+//
+//	history - A variadic parameter representing the sequence of global snapshots to be checked.
+//
+// Returns:
+//
+//	error - Returns an error if a process went from InMX to WantMX without releasing the critical
+//	        section. Returns nil otherwise.
+func checkLeavesInMXThroughNoMX(history ...[]Snapshot) error {
+	return forEachConsecutive(history, func(prev, curr Snapshot) error {
+		if prev.State == common.InMX && curr.State == common.WantMX && prev.ReqTs == curr.ReqTs {
+			return fmt.Errorf(
+				"checkLeavesInMXThroughNoMX: process %d went from InMX to WantMX for the same request (ts %d)",
+				curr.PID,
+				curr.ReqTs,
+			)
+		}
+		return nil
+	})
+}
+
+// newCheckStarvation creates a liveness heuristic that verifies that a process in the WantMX
+// state reaches the critical section within k consecutive global snapshots. A process is
+// considered to be starving if it is WantMX for the same entry request (i.e., with the same
+// request timestamp) in the last k+1 global snapshots.
+//
+// Parameters:
+//
+//	k - The maximum number of consecutive global snapshots a process may wait for the critical section.
+//
+// Returns:
+//
+//	temporalCheckerFunc - The checker, which returns an error if a process is starving, or nil otherwise.
+func newCheckStarvation(k int) temporalCheckerFunc {
+	return func(history ...[]Snapshot) error {
+		if len(history) < k+1 {
+			return nil
+		}
+		window := history[len(history)-(k+1):]
+
+		for _, snapshot := range window[len(window)-1] {
+			starving := common.All(window, func(set []Snapshot) bool {
+				s, ok := findByPID(set, snapshot.PID)
+				return ok && s.State == common.WantMX && s.ReqTs == snapshot.ReqTs
+			})
+			if starving {
+				return fmt.Errorf(
+					"checkStarvation: process %d has been WantMX for the same request (ts %d) in the last %d snapshots",
+					snapshot.PID,
+					snapshot.ReqTs,
+					k+1,
+				)
+			}
+		}
+		return nil
+	}
+}
+
+// newCheckBypass creates a checker that bounds how many times a request to the critical section
+// is bypassed, i.e., how many requests ordered after it are granted the critical section while it
+// waits. Requests are ordered as by the DIMEX module: by rank (the request timestamp moved back by
+// the given aging for each priority class), then by priority class, request timestamp and PID, so
+// a younger request of a higher priority class is only ordered after an older request of a lower
+// class once aging has caught up with it. The checker keeps, across consecutive global snapshots,
+// the distinct requests seen in the critical section while each request is waiting, so it only
+// counts the bypasses captured by the snapshots.
+//
+// Parameters:
+//
+//	n     - The maximum number of requests that may bypass a waiting request.
+//	aging - The Lamport clock ticks after which a request gains one priority class.
+//
+// Returns:
+//
+//	temporalCheckerFunc - The checker, which returns an error if a request was bypassed more than n
+//	                      times, or nil otherwise.
+func newCheckBypass(n int, aging int) temporalCheckerFunc {
+	type request struct{ pid, reqTs, priority int }
+	// orderedAfter tells whether the request one is ordered after the request other
+	orderedAfter := func(one, other request) bool {
+		oneRank, otherRank := one.reqTs-one.priority*aging, other.reqTs-other.priority*aging
+		if oneRank != otherRank {
+			return oneRank > otherRank
+		}
+		if one.priority != other.priority {
+			return one.priority < other.priority
+		}
+		return one.reqTs > other.reqTs || (one.reqTs == other.reqTs && one.pid > other.pid)
+	}
+	bypassedBy := make(map[request]map[request]bool)
+
+	return func(history ...[]Snapshot) error {
+		curr := history[len(history)-1]
+
+		waiting := make(map[request]map[request]bool)
+		for _, snapshot := range curr {
+			if snapshot.State != common.WantMX {
+				continue
+			}
+			req := request{snapshot.PID, snapshot.ReqTs, snapshot.Priority}
+			waiting[req] = bypassedBy[req]
+			if waiting[req] == nil {
+				waiting[req] = make(map[request]bool)
+			}
+		}
+		bypassedBy = waiting // os pedidos que deixaram de esperar sao esquecidos
+
+		for req, bypassers := range bypassedBy {
+			for _, snapshot := range curr {
+				holder := request{snapshot.PID, snapshot.ReqTs, snapshot.Priority}
+				if snapshot.State == common.InMX && orderedAfter(holder, req) {
+					bypassers[holder] = true
+				}
+			}
+			if len(bypassers) > n {
+				return fmt.Errorf(
+					"checkBypass: request of process %d (ts %d, priority %d) bypassed by %d requests ordered after it, more than %d",
+					req.pid,
+					req.reqTs,
+					req.priority,
+					len(bypassers),
+					n,
+				)
+			}
+		}
+		return nil
+	}
+}
+
+// forEachConsecutive applies the check to the snapshots of each process in the two most recent
+// global snapshots of the history, stopping at the first error.
+func forEachConsecutive(history [][]Snapshot, check func(prev, curr Snapshot) error) error {
+	if len(history) < 2 {
+		return nil
+	}
+
+	for _, curr := range history[len(history)-1] {
+		prev, ok := findByPID(history[len(history)-2], curr.PID)
+		if !ok {
+			continue
+		}
+		if err := check(prev, curr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findByPID finds the snapshot of the process with the given PID in a global snapshot.
+func findByPID(snapshots []Snapshot, pid int) (Snapshot, bool) {
+	for _, snapshot := range snapshots {
+		if snapshot.PID == pid {
+			return snapshot, true
+		}
+	}
+	return Snapshot{}, false
+}
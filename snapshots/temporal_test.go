@@ -0,0 +1,37 @@
+package snapshots
+
+import (
+	"pucrs/sd/common"
+	"testing"
+)
+
+func TestCheckBypassOrdersByRank(t *testing.T) {
+	// P0 waits with a request of class 0 (ts 10) while P1 holds the CS with the given request
+	bypass := func(reqTs, priority int) [][]Snapshot {
+		return [][]Snapshot{{
+			{PID: 0, State: common.WantMX, ReqTs: 10},
+			{PID: 1, State: common.InMX, ReqTs: reqTs, Priority: priority},
+		}}
+	}
+	tests := []struct {
+		name     string
+		reqTs    int
+		priority int
+		wantErr  bool
+	}{
+		{"older request", 5, 0, false},
+		{"younger request", 15, 0, true},
+		{"younger request of a higher class", 15, 1, false},
+		{"younger request of a higher class, aged out", 25, 1, true},
+		{"same rank, higher class", 20, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newCheckBypass(0, 10)(bypass(tt.reqTs, tt.priority)...)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("checkBypass = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
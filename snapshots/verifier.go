@@ -0,0 +1,109 @@
+package snapshots
+
+import "fmt"
+
+// verifier checks global snapshots (sets of snapshots with the same ID) against the invariant
+// checkers, and the sequence of global snapshots against the temporal invariant checkers. It is
+// shared by the Parser (offline verification) and the Collector (online verification).
+type verifier struct {
+	invariantCheckers []invariantCheckerFunc
+	temporalCheckers  []temporalCheckerFunc
+	history           [][]Snapshot // most recent global snapshots, for the temporal checkers
+	historyLen        int          // how many global snapshots are kept in history
+}
+
+// VerifierOpt is an option to customize how snapshots are verified.
+type VerifierOpt func(*verifier)
+
+// WithExprInvariantsOpt is an option to register invariant expressions to be checked next to
+// the built-in invariant checkers.
+func WithExprInvariantsOpt(exprs ...*Expr) VerifierOpt {
+	return func(v *verifier) {
+		for _, expr := range exprs {
+			v.invariantCheckers = append(v.invariantCheckers, expr.Check)
+		}
+	}
+}
+
+// WithStarvationBoundOpt is an option to enable the liveness heuristic that reports a process
+// that has been waiting for the critical section for more than k consecutive snapshots.
+func WithStarvationBoundOpt(k int) VerifierOpt {
+	return func(v *verifier) {
+		v.temporalCheckers = append(v.temporalCheckers, newCheckStarvation(k))
+		if k+1 > v.historyLen {
+			v.historyLen = k + 1
+		}
+	}
+}
+
+// WithBypassBoundOpt is an option to enable the checker that reports a request to the critical
+// section bypassed by more than n requests ordered after it while it waits, with the given aging of
+// the priority classes (the one of the DIMEX module).
+func WithBypassBoundOpt(n int, aging int) VerifierOpt {
+	return func(v *verifier) {
+		v.temporalCheckers = append(v.temporalCheckers, newCheckBypass(n, aging))
+	}
+}
+
+func newVerifier(opts ...VerifierOpt) *verifier {
+	v := &verifier{
+		invariantCheckers: []invariantCheckerFunc{
+			checkMutualExclusion,
+			checkNoWriterWithOtherHolders,
+			checkWaitingImpliesWantOrInCS,
+			checkIdleProcessesState,
+			checkOnlyInMXWithAllConsent,
+			checkNotOtherDelaysWhenInMX,
+			checkNotDelayingWhenNoMX,
+			checkPermissionsAccounting,
+			checkLeases,
+		},
+		temporalCheckers: []temporalCheckerFunc{
+			checkLocalClockMonotonic,
+			checkReqTsNonDecreasing,
+			checkLeavesInMXThroughNoMX,
+		},
+		historyLen: 2,
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+// verify appends the next global snapshot to the history and checks it against the invariant
+// checkers, and the history against the temporal invariant checkers. Every snapshot is appended
+// and every temporal checker is run, even after a violation, so the history (and the state of the
+// stateful checkers) stays complete for the snapshots that follow. It returns the first violation
+// found as a *ViolationError, or nil if there is none.
+func (v *verifier) verify(snapshots []Snapshot) error {
+	var previous []Snapshot
+	if len(v.history) > 0 {
+		previous = v.history[len(v.history)-1]
+	}
+	var err error
+
+	for _, checker := range v.invariantCheckers {
+		if err = checker(snapshots...); err != nil {
+			err = fmt.Errorf("invariant violation: %w", err)
+			break
+		}
+	}
+
+	v.history = append(v.history, snapshots)
+	if len(v.history) > v.historyLen {
+		v.history = v.history[1:]
+	}
+	for _, checker := range v.temporalCheckers {
+		if temporalErr := checker(v.history...); temporalErr != nil && err == nil {
+			err = fmt.Errorf("temporal invariant violation: %w", temporalErr)
+		}
+	}
+
+	if err != nil {
+		return &ViolationError{SnapID: snapshots[0].ID, Snapshots: snapshots, Previous: previous, Err: err}
+	}
+	return nil
+}
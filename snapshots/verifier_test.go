@@ -0,0 +1,36 @@
+package snapshots
+
+import (
+	"pucrs/sd/common"
+	"strings"
+	"testing"
+)
+
+func TestVerifierKeepsHistoryAfterViolation(t *testing.T) {
+	v := newVerifier()
+	sets := [][]Snapshot{
+		{
+			{ID: 0, PID: 0, State: common.NoMX, Waiting: []bool{false, false}},
+			{ID: 0, PID: 1, State: common.NoMX, Waiting: []bool{false, false}},
+		},
+		{ // both processes in the CS
+			{ID: 1, PID: 0, State: common.InMX, Waiting: []bool{false, false}, ReqTs: 3, NbrResps: 1},
+			{ID: 1, PID: 1, State: common.InMX, Waiting: []bool{false, false}, ReqTs: 4, NbrResps: 1},
+		},
+		{ // P0 back to WantMX for the same request, without leaving the CS
+			{ID: 2, PID: 0, State: common.WantMX, Waiting: []bool{false, false}, ReqTs: 3},
+			{ID: 2, PID: 1, State: common.InMX, Waiting: []bool{true, false}, ReqTs: 4, NbrResps: 1},
+		},
+	}
+	want := []string{"", "checkMutualExclusion", "checkLeavesInMXThroughNoMX"}
+
+	for i, set := range sets {
+		err := v.verify(set)
+		switch {
+		case want[i] == "" && err != nil:
+			t.Errorf("verify(snapshot %d) = %v, want no violation", i, err)
+		case want[i] != "" && (err == nil || !strings.Contains(err.Error(), want[i])):
+			t.Errorf("verify(snapshot %d) = %v, want a violation of %s", i, err, want[i])
+		}
+	}
+}
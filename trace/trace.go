@@ -0,0 +1,231 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shivizRegex is the regular expression that ShiViz uses to parse the log written by the Recorder.
+// ShiViz takes it in a field of its own, next to the log, so it is written to a separate file.
+const shivizRegex = `(?<host>\S*) (?<clock>{.*})\n(?<event>.*)`
+
+// chromeEvent is an event in the Chrome trace event format.
+// See https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type chromeEvent struct {
+	Name  string            `json:"name"`
+	Phase string            `json:"ph"`
+	Ts    int64             `json:"ts"`            // in microseconds
+	Dur   int64             `json:"dur,omitempty"` // in microseconds
+	PID   int               `json:"pid"`
+	TID   int               `json:"tid"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+// Recorder records the events of all processes of the system. It writes a log of the events with
+// vector timestamps that can be loaded in ShiViz (https://bestchai.bitbucket.io/shiviz/) and, when
+// closed, a Chrome trace event file with the critical section intervals of each process, which can
+// be loaded in chrome://tracing or https://ui.perfetto.dev.
+type Recorder struct {
+	mu         sync.Mutex
+	start      time.Time
+	shivizFile *os.File
+	shiviz     *bufio.Writer
+	chromePath string
+	chrome     []chromeEvent
+}
+
+// NewRecorder creates a new instance of a Recorder that writes the ShiViz log to shivizPath, the
+// regular expression to parse it to regexPath, and the Chrome trace event file to chromePath. The
+// user is responsible for calling Close() at the end of the execution to write the files.
+func NewRecorder(shivizPath, regexPath, chromePath string) (*Recorder, error) {
+	if err := os.WriteFile(regexPath, []byte(shivizRegex+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("NewRecorder: failed writing to '%s' file: %w", regexPath, err)
+	}
+
+	file, err := os.Create(shivizPath)
+	if err != nil {
+		return nil, fmt.Errorf("NewRecorder: failed creating '%s' file: %w", shivizPath, err)
+	}
+
+	r := &Recorder{
+		start:      time.Now(),
+		shivizFile: file,
+		shiviz:     bufio.NewWriter(file),
+		chromePath: chromePath,
+		chrome:     make([]chromeEvent, 0),
+	}
+	return r, nil
+}
+
+// Tracer creates the Tracer of the process with the given PID, in a system with n processes.
+func (r *Recorder) Tracer(pid, n int) *Tracer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.chrome = append(r.chrome, chromeEvent{
+		Name:  "process_name",
+		Phase: "M",
+		PID:   pid,
+		Args:  map[string]string{"name": fmt.Sprintf("P%d", pid)},
+	})
+
+	return &Tracer{
+		recorder: r,
+		pid:      pid,
+		clock:    make([]int, n),
+	}
+}
+
+// Close writes the Chrome trace event file and flushes the ShiViz log.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.shiviz.Flush(); err != nil {
+		return fmt.Errorf("recorder.Close: failed flushing ShiViz log: %w", err)
+	}
+	if err := r.shivizFile.Close(); err != nil {
+		return fmt.Errorf("recorder.Close: failed closing ShiViz log: %w", err)
+	}
+
+	chromeJson, err := json.Marshal(map[string][]chromeEvent{"traceEvents": r.chrome})
+	if err != nil {
+		return fmt.Errorf("recorder.Close: failed marshaling Chrome trace to JSON: %w", err)
+	}
+	if err := os.WriteFile(r.chromePath, chromeJson, 0644); err != nil {
+		return fmt.Errorf("recorder.Close: failed writing to '%s' file: %w", r.chromePath, err)
+	}
+
+	return nil
+}
+
+func (r *Recorder) logShiviz(pid int, clock []int, event string) {
+	entries := make([]string, 0, len(clock))
+	for i, ts := range clock {
+		if ts > 0 {
+			entries = append(entries, fmt.Sprintf(`"P%d":%d`, i, ts))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.shiviz, "P%d {%s}\n%s\n", pid, strings.Join(entries, ", "), event)
+}
+
+func (r *Recorder) addChrome(event chromeEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chrome = append(r.chrome, event)
+}
+
+func (r *Recorder) since(t time.Time) int64 {
+	return t.Sub(r.start).Microseconds()
+}
+
+// Tracer records the events of a single process, keeping its vector clock. Vector timestamps are
+// piggybacked on the messages sent by the process (see Send) and merged into the vector clock of
+// the receiving process (see Receive). Tracer is safe for concurrent use.
+type Tracer struct {
+	mu        sync.Mutex
+	recorder  *Recorder
+	pid       int
+	clock     []int
+	wantSince time.Time
+	inSince   time.Time
+}
+
+// Local records a local event of the process.
+func (t *Tracer) Local(event string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tick(event)
+}
+
+// Send records the event of sending a message, and returns the vector timestamp to be piggybacked
+// on the message, encoded as a string.
+func (t *Tracer) Send(event string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tick(event)
+
+	encoded := make([]string, len(t.clock))
+	for i, ts := range t.clock {
+		encoded[i] = strconv.Itoa(ts)
+	}
+	return strings.Join(encoded, ",")
+}
+
+// Receive records the event of receiving a message, merging the vector timestamp piggybacked on
+// the message (as returned by Send in the sending process) into the vector clock of the process.
+func (t *Tracer) Receive(event string, timestamp string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	encoded := strings.Split(timestamp, ",")
+	if len(encoded) != len(t.clock) {
+		return fmt.Errorf("tracer.Receive: expected %d entries in timestamp, found %d", len(t.clock), len(encoded))
+	}
+	for i, entry := range encoded {
+		ts, err := strconv.Atoi(entry)
+		if err != nil {
+			return fmt.Errorf("tracer.Receive: invalid timestamp entry '%s': %w", entry, err)
+		}
+		if ts > t.clock[i] {
+			t.clock[i] = ts
+		}
+	}
+
+	t.tick(event)
+	return nil
+}
+
+// WantCS records that the process requested the critical section.
+func (t *Tracer) WantCS(reqTs int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.wantSince = time.Now()
+	t.tick(fmt.Sprintf("request CS (ts %d)", reqTs))
+}
+
+// EnterCS records that the process entered the critical section. The time since the request is
+// recorded as a waiting interval in the Chrome trace.
+func (t *Tracer) EnterCS() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inSince = time.Now()
+	t.tick("enter CS")
+	t.recorder.addChrome(t.interval("wait", t.wantSince, t.inSince))
+}
+
+// ExitCS records that the process exited the critical section. The time since the entry is
+// recorded as a critical section interval in the Chrome trace.
+func (t *Tracer) ExitCS() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tick("exit CS")
+	t.recorder.addChrome(t.interval("CS", t.inSince, time.Now()))
+}
+
+// tick increments the entry of the process in its vector clock and logs the event. It must be
+// called with the lock held, so that the events of a process are logged in the order of its clock.
+func (t *Tracer) tick(event string) {
+	t.clock[t.pid]++
+	t.recorder.logShiviz(t.pid, t.clock, event)
+}
+
+func (t *Tracer) interval(name string, from, to time.Time) chromeEvent {
+	return chromeEvent{
+		Name:  name,
+		Phase: "X",
+		Ts:    t.recorder.since(from),
+		Dur:   to.Sub(from).Microseconds(),
+		PID:   t.pid,
+		Args:  map[string]string{"clock": fmt.Sprint(t.clock)},
+	}
+}
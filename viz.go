@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"pucrs/sd/dimex"
+	"pucrs/sd/snapshots"
+
+	"github.com/sirupsen/logrus"
+)
+
+// viz implements the "viz" subcommand, which turns the snapshot files of a run into a
+// self-contained static HTML page with a timeline of the snapshots
+func viz(args []string) {
+	fs := flag.NewFlagSet("viz", flag.ExitOnError)
+	outFile := fs.String("out", "snapshots.html", "File to write the HTML timeline to")
+	exprsFile := fs.String("i", "", "File with additional invariant expressions to be checked on the snapshots")
+	starvationK := fs.Int("k", 0, "Report processes waiting for the CS for more than k consecutive snapshots (0 disables)")
+	bypassN := fs.Int("bypass", 0, "Report requests to the CS bypassed by more than n requests ordered after them while waiting (0 disables)")
+	agingTicks := fs.Int("aging", dimex.DefaultAgingTicks, "Lamport clock ticks after which a request to the CS gained one priority class in the run")
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths, _ = filepath.Glob("snapshots-pid-*.txt")
+	}
+	if len(paths) == 0 {
+		logrus.Errorf("Usage: %s viz [-out <file>] [-i <file>] [-k <snapshots>] [-bypass <requests> [-aging <ticks>]] [<snapshots file>...]", os.Args[0])
+		os.Exit(1)
+	}
+
+	verifierOpts, err := loadVerifierOpts(*exprsFile, *starvationK, *bypassN, *agingTicks)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	snapsParser, err := snapshots.NewFilesParser(paths, verifierOpts...)
+	if err != nil {
+		logrus.Errorf("Failed to create parser: %v", err)
+		os.Exit(1)
+	}
+	if err := snapsParser.Init(); err != nil {
+		logrus.Errorf("Failed to Init parser: %v", err)
+		os.Exit(1)
+	}
+	defer snapsParser.Close()
+
+	sets, violations, err := snapsParser.ParseVerifyAll()
+	if err != nil {
+		logrus.Errorf("Failed to parse snapshots: %v", err)
+		os.Exit(1)
+	}
+
+	file, err := os.Create(*outFile)
+	if err != nil {
+		logrus.Errorf("Failed to create '%s': %v", *outFile, err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	if err := snapshots.RenderHTML(file, sets, violations); err != nil {
+		logrus.Errorf("Failed to render timeline: %v", err)
+		os.Exit(1)
+	}
+
+	logrus.Infof(
+		"Timeline of %d snapshots of %d processes (%d invariant violations) written to '%s'",
+		len(sets),
+		len(paths),
+		len(violations),
+		*outFile,
+	)
+}
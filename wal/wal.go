@@ -0,0 +1,142 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"pucrs/sd/common"
+)
+
+// compactAfter is the number of records appended to a log after which it is compacted, i.e.,
+// rewritten with only its last record
+const compactAfter = 1024
+
+// State is the state of a DIMEX process that must survive a crash: the Lamport clock, the pending
+// request (if any) and the deferred replies.
+type State struct {
+	LocalClock int          `json:"lcl"`
+	State      common.State `json:"st"`
+	ReqTs      int          `json:"reqTs"`
+	Waiting    []bool       `json:"waiting"`
+}
+
+// Log is a write-ahead log of the state of a DIMEX process. Each record is the whole state, as a
+// JSON line, and is synced to disk before Append returns, so the state must be appended before
+// any message that depends on it is sent.
+type Log struct {
+	path      string
+	file      *os.File
+	records   int
+	recovered *State
+}
+
+// Open opens the write-ahead log at the given path, creating it if it does not exist, and reads
+// the last state recorded in it (see Recovered). A last record left incomplete by a crash is
+// ignored. The user is responsible for calling Close() after all operations are completed to
+// ensure proper resource management.
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+
+	file, err := os.Open(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("wal.Open: failed opening '%s' file: %w", path, err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var state State
+			if err := json.Unmarshal(scanner.Bytes(), &state); err != nil {
+				break // incomplete record, written when the process crashed
+			}
+			l.recovered = &state
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("wal.Open: failed reading '%s' file: %w", path, err)
+		}
+	}
+
+	// the log restarts from the recovered state, which also drops an incomplete last record
+	if err := l.compact(l.recovered); err != nil {
+		return nil, fmt.Errorf("wal.Open: %w", err)
+	}
+	return l, nil
+}
+
+// Recovered returns the last state recorded in the log when it was opened, or nil if the log
+// was empty (i.e., the process has not run before).
+func (l *Log) Recovered() *State {
+	return l.recovered
+}
+
+// Append records the given state, syncing it to disk.
+func (l *Log) Append(state State) error {
+	if l.records >= compactAfter {
+		return l.compact(&state)
+	}
+
+	stateJson, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("log.Append: failed marshaling state to JSON: %w", err)
+	}
+	if _, err := l.file.Write(append(stateJson, '\n')); err != nil {
+		return fmt.Errorf("log.Append: failed writing to '%s' file: %w", l.path, err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("log.Append: failed syncing '%s' file: %w", l.path, err)
+	}
+	l.records++
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// compact replaces the log with one that only records the given state (if any), atomically, and
+// reopens it for appending.
+func (l *Log) compact(state *State) error {
+	tmpPath := l.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("log.compact: failed creating '%s' file: %w", tmpPath, err)
+	}
+	if state != nil {
+		stateJson, err := json.Marshal(*state)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("log.compact: failed marshaling state to JSON: %w", err)
+		}
+		if _, err := tmp.Write(append(stateJson, '\n')); err != nil {
+			tmp.Close()
+			return fmt.Errorf("log.compact: failed writing to '%s' file: %w", tmpPath, err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("log.compact: failed syncing '%s' file: %w", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("log.compact: failed closing '%s' file: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("log.compact: failed renaming '%s' file: %w", tmpPath, err)
+	}
+
+	if l.file != nil {
+		l.file.Close()
+	}
+	if l.file, err = os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return fmt.Errorf("log.compact: failed opening '%s' file: %w", l.path, err)
+	}
+	l.records = 0
+	if state != nil {
+		l.records = 1
+	}
+	return nil
+}
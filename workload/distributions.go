@@ -0,0 +1,104 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Distribution is a probability distribution of durations, used for think times (between an exit
+// from the critical section and the next entry request) and hold times (inside the critical section).
+type Distribution interface {
+	Sample(r *rand.Rand) time.Duration
+	String() string
+}
+
+// Constant is a distribution that always yields the same duration.
+type Constant time.Duration
+
+// Sample returns the constant duration.
+func (c Constant) Sample(_ *rand.Rand) time.Duration {
+	return time.Duration(c)
+}
+
+func (c Constant) String() string {
+	return fmt.Sprintf("const:%v", time.Duration(c))
+}
+
+// Uniform is a distribution that yields durations uniformly between Min and Max.
+type Uniform struct {
+	Min, Max time.Duration
+}
+
+// Sample returns a duration uniformly distributed between Min and Max.
+func (u Uniform) Sample(r *rand.Rand) time.Duration {
+	if u.Max <= u.Min {
+		return u.Min
+	}
+	return u.Min + time.Duration(r.Int63n(int64(u.Max-u.Min)+1))
+}
+
+func (u Uniform) String() string {
+	return fmt.Sprintf("uniform:%v-%v", u.Min, u.Max)
+}
+
+// Exponential is a distribution that yields exponentially distributed durations with the given
+// mean, which models the time between independent events (e.g., requests of a Poisson process).
+type Exponential struct {
+	Mean time.Duration
+}
+
+// Sample returns an exponentially distributed duration.
+func (e Exponential) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(e.Mean))
+}
+
+func (e Exponential) String() string {
+	return fmt.Sprintf("exp:%v", e.Mean)
+}
+
+// ParseDistribution parses the specification of a distribution, which is one of:
+//   - "<duration>" or "const:<duration>", for a constant duration (e.g., "10ms");
+//   - "uniform:<min>-<max>", for a uniform distribution (e.g., "uniform:5ms-20ms");
+//   - "exp:<mean>", for an exponential distribution (e.g., "exp:10ms").
+func ParseDistribution(spec string) (Distribution, error) {
+	kind, params, found := strings.Cut(spec, ":")
+	if !found {
+		kind, params = "const", spec
+	}
+
+	switch kind {
+	case "const":
+		d, err := time.ParseDuration(params)
+		if err != nil {
+			return nil, fmt.Errorf("ParseDistribution: invalid constant duration '%s': %w", params, err)
+		}
+		return Constant(d), nil
+	case "uniform":
+		minSpec, maxSpec, found := strings.Cut(params, "-")
+		if !found {
+			return nil, fmt.Errorf("ParseDistribution: expected '<min>-<max>' in uniform distribution, found '%s'", params)
+		}
+		min, err := time.ParseDuration(minSpec)
+		if err != nil {
+			return nil, fmt.Errorf("ParseDistribution: invalid minimum duration '%s': %w", minSpec, err)
+		}
+		max, err := time.ParseDuration(maxSpec)
+		if err != nil {
+			return nil, fmt.Errorf("ParseDistribution: invalid maximum duration '%s': %w", maxSpec, err)
+		}
+		if max < min {
+			return nil, fmt.Errorf("ParseDistribution: maximum duration %v less than minimum duration %v", max, min)
+		}
+		return Uniform{Min: min, Max: max}, nil
+	case "exp":
+		mean, err := time.ParseDuration(params)
+		if err != nil {
+			return nil, fmt.Errorf("ParseDistribution: invalid mean duration '%s': %w", params, err)
+		}
+		return Exponential{Mean: mean}, nil
+	}
+
+	return nil, fmt.Errorf("ParseDistribution: unknown distribution '%s'", kind)
+}
@@ -0,0 +1,297 @@
+package workload
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"pucrs/sd/dimex"
+	"pucrs/sd/fencing"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pattern is the pattern in which a process issues its requests to the critical section.
+type Pattern int
+
+const (
+	// Steady issues requests one at a time, with a think time between them.
+	Steady Pattern = iota
+	// Bursty issues requests in bursts of back-to-back requests, with the think times of the whole
+	// burst between bursts (so the average request rate is the same as with Steady).
+	Bursty
+	// Skewed makes the processes issue requests at different rates: the think times of the process
+	// with PID i are multiplied by i+1, so lower PIDs contend for the critical section more often.
+	Skewed
+)
+
+// ParsePattern parses the name of a pattern (steady, bursty or skewed).
+func ParsePattern(name string) (Pattern, error) {
+	switch name {
+	case "steady":
+		return Steady, nil
+	case "bursty":
+		return Bursty, nil
+	case "skewed":
+		return Skewed, nil
+	}
+	return Steady, fmt.Errorf("ParsePattern: unknown pattern '%s'", name)
+}
+
+// Budget is a total number of requests to the critical section shared by all processes.
+type Budget struct {
+	remaining int64
+}
+
+// NewBudget creates a new Budget of n requests.
+func NewBudget(n int) *Budget {
+	return &Budget{remaining: int64(n)}
+}
+
+// take consumes one request from the budget, and tells whether there was one left.
+func (b *Budget) take() bool {
+	return atomic.AddInt64(&b.remaining, -1) >= 0
+}
+
+// Gate pauses and resumes the requests to the critical section issued by a process (e.g., from an
+// admin API), without interrupting an access already in progress. It is also closed to stop the
+// requests for good (e.g., before the process leaves the group).
+type Gate struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{} // closed when the gate is resumed
+	closed  chan struct{} // closed when the gate is closed
+}
+
+// NewGate creates a new Gate, initially open (not paused).
+func NewGate() *Gate {
+	return &Gate{closed: make(chan struct{})}
+}
+
+// Pause stops the process from issuing new requests until Resume is called.
+func (g *Gate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		g.paused = true
+		g.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets the process issue requests again.
+func (g *Gate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resumed)
+	}
+}
+
+// Close stops the process from issuing new requests for good, even if the gate is paused.
+func (g *Gate) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.closed:
+	default:
+		close(g.closed)
+	}
+}
+
+// Paused tells whether the gate is paused.
+func (g *Gate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// wait blocks while the gate is paused, and tells whether the process may issue a new request
+// (i.e., the gate is not closed).
+func (g *Gate) wait() bool {
+	g.mu.Lock()
+	resumed, paused := g.resumed, g.paused
+	g.mu.Unlock()
+	if paused {
+		select {
+		case <-resumed:
+		case <-g.closed:
+		}
+	}
+	select {
+	case <-g.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Config configures the workload of a process.
+type Config struct {
+	Warmup     time.Duration // time to wait before the first request, so all processes can be initialized
+	Think      Distribution  // time between an exit from the critical section and the next request
+	Hold       Distribution  // time inside the critical section
+	Pattern    Pattern       // pattern in which requests are issued
+	BurstSize  int           // number of back-to-back requests in a burst (with the Bursty pattern)
+	Rates      []float64     // relative request rate of each process, by PID (think times are divided by it)
+	ReadRatio  float64       // fraction of the requests issued in shared mode (readers), between 0 and 1
+	Priorities []int         // priority class of the requests of each process, by PID (0 if not set)
+	Budget     *Budget       // total number of requests shared by all processes (nil for unlimited)
+	Duration   time.Duration // for how long requests are issued, after the warm-up (0 for unlimited)
+	Seed       int64         // seed of the pseudo-random number generator (combined with the PID)
+	Gate       *Gate         // pauses and resumes the requests of the process (nil to never pause)
+}
+
+// DefaultConfig returns the configuration of the original workload: after a 2 seconds warm-up,
+// requests are issued back-to-back and the critical section is exited immediately, forever.
+func DefaultConfig() Config {
+	return Config{
+		Warmup:    2 * time.Second,
+		Think:     Constant(0),
+		Hold:      Constant(0),
+		Pattern:   Steady,
+		BurstSize: 1,
+		Seed:      time.Now().UnixNano(),
+	}
+}
+
+// Resource is the resource protected by the critical section, which is accessed by the workload
+// right after entering the critical section, with the fencing token and the mode of the entry, and
+// right before exiting it. A resource that validates the tokens (see fencing.Validator) rejects the accesses of
+// a former holder with an error wrapping fencing.ErrStaleToken, which is not fatal to the workload.
+type Resource interface {
+	Enter(token fencing.Token, shared bool) error
+	Exit() error
+}
+
+// Access is an access of a process to the critical section.
+type Access struct {
+	Requested time.Time     // when the process requested the critical section
+	Entered   time.Time     // when the process entered the critical section
+	Exited    time.Time     // when the process exited the critical section
+	Expired   bool          // whether the lease of the critical section expired before the process exited it
+	Token     fencing.Token // fencing token of the entry in the critical section
+	Shared    bool          // whether the process held the critical section in shared mode (reader)
+	Fenced    bool          // whether the resource rejected the token (a newer holder accessed it meanwhile)
+}
+
+// Stats are the statistics of the requests to the critical section issued by a process.
+type Stats struct {
+	PID      int
+	Entries  int           // number of entries in the critical section
+	Expired  int           // number of entries whose lease expired before the process exited them
+	Fenced   int           // number of entries whose accesses to the resource were rejected as stale
+	Accesses []Access      // accesses to the critical section, in order
+	Elapsed  time.Duration // time spent issuing requests (after the warm-up)
+}
+
+// Run simulates the flow of an application that uses the DIMEX module, issuing requests to the
+// critical section as configured until the budget is exhausted, the duration is over or the gate is
+// closed (or forever, if none of them happens). Each time the process is in the critical section, it
+// accesses the resource (which may be nil) and holds the critical section for the configured hold
+// time.
+func Run(dmx *dimex.Dimex, pid int, cfg Config, resource Resource) (stats Stats, err error) {
+	stats = Stats{PID: pid, Accesses: make([]Access, 0)}
+	r := rand.New(rand.NewSource(cfg.Seed + int64(pid)))
+	if pid < len(cfg.Priorities) {
+		dmx.SetPriority(cfg.Priorities[pid])
+	}
+
+	// wait so all processes can be initialized
+	time.Sleep(cfg.Warmup)
+
+	start := time.Now()
+	defer func() { stats.Elapsed = time.Since(start) }()
+
+	for request := 0; ; request++ {
+		if cfg.Duration > 0 && time.Since(start) >= cfg.Duration {
+			return stats, nil
+		}
+		if cfg.Gate != nil && !cfg.Gate.wait() {
+			return stats, nil
+		}
+		if cfg.Budget != nil && !cfg.Budget.take() {
+			return stats, nil
+		}
+
+		// asks to access the DIMEX and waits for it to be released by other processes
+		access := Access{Requested: time.Now()}
+		if cfg.ReadRatio > 0 && r.Float64() < cfg.ReadRatio {
+			access.Shared = true
+			dmx.Req <- dimex.ENTER_SHARED
+		} else {
+			dmx.Req <- dimex.ENTER
+		}
+		resp := <-dmx.Ind
+		for resp.Expired { // the lease of the previous access expired right before it was exited
+			resp = <-dmx.Ind
+		}
+		if resp.Err != nil {
+			return stats, fmt.Errorf("workload.Run (pid %d): request to the CS rejected: %w", pid, resp.Err)
+		}
+		access.Entered = time.Now()
+		access.Token = resp.Token
+		stats.Entries++
+
+		if resource != nil {
+			if err := resource.Enter(resp.Token, access.Shared); err != nil {
+				if !errors.Is(err, fencing.ErrStaleToken) {
+					return stats, fmt.Errorf("workload.Run (pid %d): error accessing resource: %w", pid, err)
+				}
+				access.Fenced = true
+			}
+		}
+		time.Sleep(cfg.Hold.Sample(r))
+		select {
+		case resp := <-dmx.Ind:
+			access.Expired = resp.Expired
+		default:
+		}
+		if access.Expired {
+			stats.Expired++
+		}
+		if resource != nil && !access.Fenced {
+			if err := resource.Exit(); err != nil {
+				if !errors.Is(err, fencing.ErrStaleToken) {
+					return stats, fmt.Errorf("workload.Run (pid %d): error accessing resource: %w", pid, err)
+				}
+				access.Fenced = true
+			}
+		}
+		if access.Fenced {
+			stats.Fenced++
+		}
+
+		// release the DIMEX module
+		access.Exited = time.Now()
+		dmx.Req <- dimex.EXIT
+		stats.Accesses = append(stats.Accesses, access)
+
+		time.Sleep(cfg.thinkTime(r, pid, request))
+	}
+}
+
+// thinkTime samples the think time after the given request of the process, according to the
+// pattern and the request rate of the process.
+func (cfg Config) thinkTime(r *rand.Rand, pid, request int) time.Duration {
+	think := cfg.Think.Sample(r)
+
+	switch cfg.Pattern {
+	case Bursty:
+		burstSize := cfg.BurstSize
+		if burstSize < 1 {
+			burstSize = 1
+		}
+		if (request+1)%burstSize != 0 {
+			return 0 // in the middle of a burst
+		}
+		think *= time.Duration(burstSize)
+	case Skewed:
+		think *= time.Duration(pid + 1)
+	}
+
+	if pid < len(cfg.Rates) && cfg.Rates[pid] > 0 {
+		think = time.Duration(float64(think) / cfg.Rates[pid])
+	}
+	return think
+}